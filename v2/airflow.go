@@ -0,0 +1,413 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// returned when no backend of a Router declares the capability an operation needs
+var ErrNoBackend = errors.New("no backend supports this operation")
+
+// a group of operations a backend is able to serve
+type Capability string
+
+const (
+	CapabilityDags        Capability = "dags"
+	CapabilityDagRuns     Capability = "dag_runs"
+	CapabilityTasks       Capability = "tasks"
+	CapabilityVariables   Capability = "variables"
+	CapabilityConnections Capability = "connections"
+	CapabilityProviders   Capability = "providers"
+	CapabilityRoles       Capability = "roles"
+	CapabilityVersion     Capability = "version"
+)
+
+// every Capability, in routing precedence order
+var AllCapabilities = []Capability{
+	CapabilityDags,
+	CapabilityDagRuns,
+	CapabilityTasks,
+	CapabilityVariables,
+	CapabilityConnections,
+	CapabilityProviders,
+	CapabilityRoles,
+	CapabilityVersion,
+}
+
+// operations on DAGs and the dagbag
+type DagAPI interface {
+	GetDags() (Dags, error)
+	DeleteDag(dagId string) error
+	PauseDag(dagId string) error
+	UnpauseDag(dagId string) error
+	DagsReport() (DagReport, error)
+	DagShow(dagId string) (string, error)
+	GetDagJobs(i DagJobsInput) (DagJobs, error)
+}
+
+// operations on DAG runs
+type DagRunAPI interface {
+	GetAllDagRuns() ([]airflow.DAGRun, error)
+	GetDagRuns(dagRun airflow.DAGRun) ([]airflow.DAGRun, error)
+	NewDagRun(dagRun airflow.DAGRun) (*airflow.DAGRun, error)
+	GetDagState(dagId string, executionDate time.Time) (*airflow.DagState, error)
+}
+
+// operations on tasks and task instances
+type TaskAPI interface {
+	GetDagTasks(dagId string) ([]DagTask, error)
+	GetTaskFailedDeps(dagId string, taskId string, executionDate airflow.NullableTime, runId airflow.NullableString) (MWAAData, error)
+	GetTaskState(dagId string, taskId string, executionDate airflow.NullableTime, runId airflow.NullableString) (airflow.DagState, error)
+	GetTaskStatesDetailed(dagId string, executionDate airflow.NullableTime, runId airflow.NullableString) ([]TaskStatesDetailed, error)
+}
+
+// operations on airflow variables
+type VariableAPI interface {
+	GetVariables() (Variables, error)
+	GetVariableNoSerialize(key string) (string, error)
+	GetVariableSerialize(key string) ([]byte, error)
+	SetVariableNoSerialize(key string, val string) error
+	SetVariableSerialize(key string, val string) error
+	DeleteVariable(key string) error
+}
+
+// operations on airflow connections
+type ConnectionAPI interface {
+	AddConnection(conn Connection) error
+	DeleteConnection(connectionId string) error
+}
+
+// operations on installed providers
+type ProviderAPI interface {
+	GetProviders() ([]airflow.Provider, error)
+	GetProviderDetailed(providerName string) (ProviderDetailed, error)
+	GetProviderHooks() (ProviderHooks, error)
+	GetProviderLinks() (ProviderLinks, error)
+	GetProvidersBehaviours() (ProvidersBehaviours, error)
+}
+
+// operations on roles
+type RoleAPI interface {
+	GetRoles() (Roles, error)
+}
+
+// operations on the airflow installation itself
+type VersionAPI interface {
+	GetVersion() (string, error)
+}
+
+// Airflow is the set of operations mwaah exposes, independent of how they reach airflow.
+// *CLIENT implements it over the MWAA cli endpoint; other backends (REST, a local runner, mocks) may implement it too.
+type Airflow interface {
+	DagAPI
+	DagRunAPI
+	TaskAPI
+	VariableAPI
+	ConnectionAPI
+	ProviderAPI
+	RoleAPI
+	VersionAPI
+}
+
+// an Airflow implementation that declares which operation groups it can serve
+type Backend interface {
+	Airflow
+	Capabilities() []Capability
+}
+
+var _ Backend = (*CLIENT)(nil)
+var _ Airflow = (*Router)(nil)
+
+// the MWAA cli endpoint serves every operation
+func (cli *CLIENT) Capabilities() []Capability {
+	return AllCapabilities
+}
+
+/*
+Router implements Airflow by sending each operation to the first backend that declares its Capability.
+
+Backends are consulted in the order they were given to NewRouter, so list preferred backends first.
+*/
+type Router struct {
+	backends []Backend
+}
+
+/*
+NewRouter creates a Router over backends
+
+@param backends ...Backend - candidate backends, in order of preference.
+
+@return *Router
+*/
+func NewRouter(backends ...Backend) *Router {
+	return &Router{backends: backends}
+}
+
+// returns the first backend declaring capability c
+func (r *Router) Backend(c Capability) (Backend, error) {
+	for _, b := range r.backends {
+		for _, bc := range b.Capabilities() {
+			if bc == c {
+				return b, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoBackend, c)
+}
+
+// union of the capabilities of every backend
+func (r *Router) Capabilities() []Capability {
+	var caps []Capability
+	for _, c := range AllCapabilities {
+		if _, err := r.Backend(c); err == nil {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+func (r *Router) GetDags() (Dags, error) {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return Dags{}, err
+	}
+	return b.GetDags()
+}
+
+func (r *Router) DeleteDag(dagId string) error {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return err
+	}
+	return b.DeleteDag(dagId)
+}
+
+func (r *Router) PauseDag(dagId string) error {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return err
+	}
+	return b.PauseDag(dagId)
+}
+
+func (r *Router) UnpauseDag(dagId string) error {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return err
+	}
+	return b.UnpauseDag(dagId)
+}
+
+func (r *Router) DagsReport() (DagReport, error) {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return DagReport{}, err
+	}
+	return b.DagsReport()
+}
+
+func (r *Router) DagShow(dagId string) (string, error) {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return "", err
+	}
+	return b.DagShow(dagId)
+}
+
+func (r *Router) GetDagJobs(i DagJobsInput) (DagJobs, error) {
+	b, err := r.Backend(CapabilityDags)
+	if err != nil {
+		return DagJobs{}, err
+	}
+	return b.GetDagJobs(i)
+}
+
+func (r *Router) GetAllDagRuns() ([]airflow.DAGRun, error) {
+	b, err := r.Backend(CapabilityDagRuns)
+	if err != nil {
+		return []airflow.DAGRun{}, err
+	}
+	return b.GetAllDagRuns()
+}
+
+func (r *Router) GetDagRuns(dagRun airflow.DAGRun) ([]airflow.DAGRun, error) {
+	b, err := r.Backend(CapabilityDagRuns)
+	if err != nil {
+		return []airflow.DAGRun{}, err
+	}
+	return b.GetDagRuns(dagRun)
+}
+
+func (r *Router) NewDagRun(dagRun airflow.DAGRun) (*airflow.DAGRun, error) {
+	b, err := r.Backend(CapabilityDagRuns)
+	if err != nil {
+		return &airflow.DAGRun{}, err
+	}
+	return b.NewDagRun(dagRun)
+}
+
+func (r *Router) GetDagState(dagId string, executionDate time.Time) (*airflow.DagState, error) {
+	b, err := r.Backend(CapabilityDagRuns)
+	if err != nil {
+		ev := airflow.DagState("")
+		return &ev, err
+	}
+	return b.GetDagState(dagId, executionDate)
+}
+
+func (r *Router) GetDagTasks(dagId string) ([]DagTask, error) {
+	b, err := r.Backend(CapabilityTasks)
+	if err != nil {
+		return []DagTask{}, err
+	}
+	return b.GetDagTasks(dagId)
+}
+
+func (r *Router) GetTaskFailedDeps(dagId string, taskId string, executionDate airflow.NullableTime, runId airflow.NullableString) (MWAAData, error) {
+	b, err := r.Backend(CapabilityTasks)
+	if err != nil {
+		return MWAAData{}, err
+	}
+	return b.GetTaskFailedDeps(dagId, taskId, executionDate, runId)
+}
+
+func (r *Router) GetTaskState(dagId string, taskId string, executionDate airflow.NullableTime, runId airflow.NullableString) (airflow.DagState, error) {
+	b, err := r.Backend(CapabilityTasks)
+	if err != nil {
+		return airflow.DagState(""), err
+	}
+	return b.GetTaskState(dagId, taskId, executionDate, runId)
+}
+
+func (r *Router) GetTaskStatesDetailed(dagId string, executionDate airflow.NullableTime, runId airflow.NullableString) ([]TaskStatesDetailed, error) {
+	b, err := r.Backend(CapabilityTasks)
+	if err != nil {
+		return []TaskStatesDetailed{}, err
+	}
+	return b.GetTaskStatesDetailed(dagId, executionDate, runId)
+}
+
+func (r *Router) GetVariables() (Variables, error) {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return Variables{}, err
+	}
+	return b.GetVariables()
+}
+
+func (r *Router) GetVariableNoSerialize(key string) (string, error) {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return "", err
+	}
+	return b.GetVariableNoSerialize(key)
+}
+
+func (r *Router) GetVariableSerialize(key string) ([]byte, error) {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return []byte{}, err
+	}
+	return b.GetVariableSerialize(key)
+}
+
+func (r *Router) SetVariableNoSerialize(key string, val string) error {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return err
+	}
+	return b.SetVariableNoSerialize(key, val)
+}
+
+func (r *Router) SetVariableSerialize(key string, val string) error {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return err
+	}
+	return b.SetVariableSerialize(key, val)
+}
+
+func (r *Router) DeleteVariable(key string) error {
+	b, err := r.Backend(CapabilityVariables)
+	if err != nil {
+		return err
+	}
+	return b.DeleteVariable(key)
+}
+
+func (r *Router) AddConnection(conn Connection) error {
+	b, err := r.Backend(CapabilityConnections)
+	if err != nil {
+		return err
+	}
+	return b.AddConnection(conn)
+}
+
+func (r *Router) DeleteConnection(connectionId string) error {
+	b, err := r.Backend(CapabilityConnections)
+	if err != nil {
+		return err
+	}
+	return b.DeleteConnection(connectionId)
+}
+
+func (r *Router) GetProviders() ([]airflow.Provider, error) {
+	b, err := r.Backend(CapabilityProviders)
+	if err != nil {
+		return []airflow.Provider{}, err
+	}
+	return b.GetProviders()
+}
+
+func (r *Router) GetProviderDetailed(providerName string) (ProviderDetailed, error) {
+	b, err := r.Backend(CapabilityProviders)
+	if err != nil {
+		return ProviderDetailed{}, err
+	}
+	return b.GetProviderDetailed(providerName)
+}
+
+func (r *Router) GetProviderHooks() (ProviderHooks, error) {
+	b, err := r.Backend(CapabilityProviders)
+	if err != nil {
+		return ProviderHooks{}, err
+	}
+	return b.GetProviderHooks()
+}
+
+func (r *Router) GetProviderLinks() (ProviderLinks, error) {
+	b, err := r.Backend(CapabilityProviders)
+	if err != nil {
+		return ProviderLinks{}, err
+	}
+	return b.GetProviderLinks()
+}
+
+func (r *Router) GetProvidersBehaviours() (ProvidersBehaviours, error) {
+	b, err := r.Backend(CapabilityProviders)
+	if err != nil {
+		return ProvidersBehaviours{}, err
+	}
+	return b.GetProvidersBehaviours()
+}
+
+func (r *Router) GetRoles() (Roles, error) {
+	b, err := r.Backend(CapabilityRoles)
+	if err != nil {
+		return Roles{}, err
+	}
+	return b.GetRoles()
+}
+
+func (r *Router) GetVersion() (string, error) {
+	b, err := r.Backend(CapabilityVersion)
+	if err != nil {
+		return "", err
+	}
+	return b.GetVersion()
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// returned when MWAA does not permit a command on the environment's airflow version
+var ErrCommandNotAllowed = errors.New("command not allowed by MWAA")
+
+// an airflow cli command MWAA permits, from the first airflow version it is permitted on
+type AllowedCommand struct {
+	Command string
+	Since   Version
+}
+
+// the airflow cli commands MWAA permits, per airflow version
+// https://docs.aws.amazon.com/mwaa/latest/userguide/airflow-cli-command-reference.html
+var MWAAAllowedCommands = []AllowedCommand{
+	{Command: "cheat-sheet", Since: ver(2, 0, 0)},
+	{Command: "config get-value", Since: ver(2, 0, 0)},
+	{Command: "connections add", Since: ver(2, 0, 0)},
+	{Command: "connections delete", Since: ver(2, 0, 0)},
+	{Command: "dags backfill", Since: ver(2, 0, 0)},
+	{Command: "dags delete", Since: ver(2, 0, 0)},
+	{Command: "dags details", Since: ver(2, 8, 1)},
+	{Command: "dags list", Since: ver(2, 2, 2)},
+	{Command: "dags list-import-errors", Since: ver(2, 4, 3)},
+	{Command: "dags list-jobs", Since: ver(2, 0, 0)},
+	{Command: "dags list-runs", Since: ver(2, 2, 2)},
+	{Command: "dags next-execution", Since: ver(2, 0, 0)},
+	{Command: "dags pause", Since: ver(2, 0, 0)},
+	{Command: "dags report", Since: ver(2, 0, 0)},
+	{Command: "dags reserialize", Since: ver(2, 6, 3)},
+	{Command: "dags show", Since: ver(2, 0, 0)},
+	{Command: "dags state", Since: ver(2, 0, 0)},
+	{Command: "dags test", Since: ver(2, 0, 0)},
+	{Command: "dags trigger", Since: ver(2, 0, 0)},
+	{Command: "dags unpause", Since: ver(2, 0, 0)},
+	{Command: "db check", Since: ver(2, 0, 0)},
+	{Command: "db clean", Since: ver(2, 7, 2)},
+	{Command: "info", Since: ver(2, 0, 0)},
+	{Command: "plugins", Since: ver(2, 0, 0)},
+	{Command: "providers behaviours", Since: ver(2, 0, 0)},
+	{Command: "providers get", Since: ver(2, 0, 0)},
+	{Command: "providers hooks", Since: ver(2, 0, 0)},
+	{Command: "providers links", Since: ver(2, 0, 0)},
+	{Command: "providers list", Since: ver(2, 0, 0)},
+	{Command: "providers widgets", Since: ver(2, 0, 0)},
+	{Command: "roles create", Since: ver(2, 0, 0)},
+	{Command: "roles list", Since: ver(2, 0, 0)},
+	{Command: "tasks clear", Since: ver(2, 0, 0)},
+	{Command: "tasks failed-deps", Since: ver(2, 0, 0)},
+	{Command: "tasks list", Since: ver(2, 0, 0)},
+	{Command: "tasks render", Since: ver(2, 0, 0)},
+	{Command: "tasks run", Since: ver(2, 0, 0)},
+	{Command: "tasks state", Since: ver(2, 0, 0)},
+	{Command: "tasks states-for-dag-run", Since: ver(2, 0, 0)},
+	{Command: "tasks test", Since: ver(2, 0, 0)},
+	{Command: "variables delete", Since: ver(2, 0, 0)},
+	{Command: "variables get", Since: ver(2, 0, 0)},
+	{Command: "variables list", Since: ver(2, 0, 0)},
+	{Command: "variables set", Since: ver(2, 0, 0)},
+	{Command: "version", Since: ver(2, 0, 0)},
+}
+
+// returns the sorted commands MWAA permits on airflow version v
+func AllowedCommands(v Version) []string {
+	var commands []string
+	for _, c := range MWAAAllowedCommands {
+		if v.AtLeast(c.Since) {
+			commands = append(commands, c.Command)
+		}
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// returns the commands MWAA permits on airflow version v, grouped by top level command
+// commands without subcommands map to an empty slice
+func AllowedCommandTree(v Version) map[string][]string {
+	tree := map[string][]string{}
+	for _, command := range AllowedCommands(v) {
+		parts := strings.SplitN(command, " ", 2)
+		if len(parts) == 1 {
+			if _, ok := tree[parts[0]]; !ok {
+				tree[parts[0]] = []string{}
+			}
+			continue
+		}
+		tree[parts[0]] = append(tree[parts[0]], parts[1])
+	}
+	return tree
+}
+
+// returns nil if MWAA permits command on airflow version v, otherwise an error wrapping ErrCommandNotAllowed
+func CheckAllowed(v Version, command string) error {
+	for _, c := range MWAAAllowedCommands {
+		if c.Command != command {
+			continue
+		}
+		if !v.AtLeast(c.Since) {
+			return fmt.Errorf("%w: `%s` requires airflow %s on MWAA, environment runs %s", ErrCommandNotAllowed, command, c.Since, v)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: `%s`", ErrCommandNotAllowed, command)
+}
+
+// returns the sorted commands MWAA permits on this environment
+func (cli *CLIENT) AllowedCommands() ([]string, error) {
+	v, err := cli.AirflowVersion()
+	if err != nil {
+		return []string{}, err
+	}
+	return AllowedCommands(v), nil
+}
+
+// validates a cmd string against the allowlist and the capability matrix
+func (cli *CLIENT) validateCommand(cmd string) error {
+	p := parseCommand(cmd)
+	// detecting the version itself needs no validation
+	if p.Command == "version" {
+		return nil
+	}
+	v, err := cli.AirflowVersion()
+	if err != nil {
+		return err
+	}
+	if err := CheckAllowed(v, p.Command); err != nil {
+		return err
+	}
+	return CheckSupport(v, p.Command, p.Flags...)
+}
+
+/*
+Exec runs an arbitrary airflow cli command, validated against the MWAA allowlist before it is sent
+
+@param args ...string - the command words, flags and arguments, e.g. "dags", "list", "--output", "json". Arguments are quoted as needed.
+
+@return MWAAData
+*/
+func (cli *CLIENT) Exec(args ...string) (MWAAData, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return PostMWAACommand(cli, strings.Join(quoted, " "))
+}
+
+// single quotes arg unless it is a plain word or flag
+func quoteArg(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == '.' || r == '=' || r == ',' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) == -1 {
+		return arg
+	}
+	return `'` + strings.ReplaceAll(arg, `'`, `'"'"'`) + `'`
+}
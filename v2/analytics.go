@@ -0,0 +1,233 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// run statistics of one DAG, or of several combined, over a time window
+type RunStats struct {
+	// empty for combined stats
+	DagId string    `json:"dag_id,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total int       `json:"total"`
+	// run count per state
+	ByState map[string]int `json:"by_state"`
+	// successful runs out of the finished ones, 0 when none finished
+	SuccessRate float64 `json:"success_rate"`
+	// durations of the finished runs
+	MeanDuration time.Duration `json:"mean_duration"`
+	P50Duration  time.Duration `json:"p50_duration"`
+	P95Duration  time.Duration `json:"p95_duration"`
+	MaxDuration  time.Duration `json:"max_duration"`
+}
+
+// stats of a window next to those of the window before it
+type RunTrend struct {
+	Current  RunStats `json:"current"`
+	Previous RunStats `json:"previous"`
+	// Current minus Previous
+	SuccessRateDelta  float64       `json:"success_rate_delta"`
+	MeanDurationDelta time.Duration `json:"mean_duration_delta"`
+	P95DurationDelta  time.Duration `json:"p95_duration_delta"`
+}
+
+type RunAnalyticsInput struct {
+	DagIds []string
+	// analyse the DAGs matching Selector in addition to DagIds
+	Selector *DagQuery
+	// window of run logical dates, End excluded; the previous window is the same length, ending at Start
+	Start time.Time
+	End   time.Time
+	// leave out backfill runs
+	NoBackfill bool
+}
+
+type RunAnalytics struct {
+	// per DAG, sorted by dag id
+	Dags []RunTrend `json:"dags"`
+	// every DAG combined
+	Total RunTrend `json:"total"`
+}
+
+// returns the logical date of a run, falling back to the execution date
+func runLogicalDate(run airflow.DAGRun) time.Time {
+	if run.HasLogicalDate() {
+		return run.GetLogicalDate()
+	}
+	return run.GetExecutionDate()
+}
+
+// returns the value at percentile p of sorted durations, nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+/*
+ComputeRunStats computes the stats of the runs with a logical date in [start, end)
+
+@param dagId string - recorded in the result, empty for stats over several DAGs
+*/
+func ComputeRunStats(dagId string, runs []airflow.DAGRun, start time.Time, end time.Time) RunStats {
+	stats := RunStats{DagId: dagId, Start: start, End: end, ByState: map[string]int{}}
+	var durations []time.Duration
+	for _, run := range runs {
+		date := runLogicalDate(run)
+		if date.Before(start) || !date.Before(end) {
+			continue
+		}
+		stats.Total++
+		state := run.GetState()
+		stats.ByState[string(state)]++
+		if isTerminalDagState(state) && run.HasStartDate() && run.HasEndDate() {
+			durations = append(durations, run.GetEndDate().Sub(run.GetStartDate()))
+		}
+	}
+	success, failed := stats.ByState[string(airflow.DAGSTATE_SUCCESS)], stats.ByState[string(airflow.DAGSTATE_FAILED)]
+	if success+failed > 0 {
+		stats.SuccessRate = float64(success) / float64(success+failed)
+	}
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		stats.MeanDuration = total / time.Duration(len(durations))
+		stats.P50Duration = percentile(durations, 50)
+		stats.P95Duration = percentile(durations, 95)
+		stats.MaxDuration = durations[len(durations)-1]
+	}
+	return stats
+}
+
+// returns current next to previous
+func CompareRunStats(current RunStats, previous RunStats) RunTrend {
+	return RunTrend{
+		Current:           current,
+		Previous:          previous,
+		SuccessRateDelta:  current.SuccessRate - previous.SuccessRate,
+		MeanDurationDelta: current.MeanDuration - previous.MeanDuration,
+		P95DurationDelta:  current.P95Duration - previous.P95Duration,
+	}
+}
+
+/*
+GetRunAnalytics computes run stats per DAG, and combined, over input's window and the window before it
+
+@return RunAnalytics - a RunTrend per DAG and one over every DAG
+*/
+func (cli *CLIENT) GetRunAnalytics(input RunAnalyticsInput) (RunAnalytics, error) {
+	if !input.Start.Before(input.End) {
+		return RunAnalytics{}, errors.New("RunAnalyticsInput.Start must be before End")
+	}
+	dagIds := map[string]bool{}
+	for _, dagId := range input.DagIds {
+		dagIds[dagId] = true
+	}
+	if input.Selector != nil {
+		dags, err := cli.QueryDags(*input.Selector)
+		if err != nil {
+			return RunAnalytics{}, err
+		}
+		for _, d := range dags {
+			dagIds[d.DagId] = true
+		}
+	}
+	sorted := []string{}
+	for dagId := range dagIds {
+		sorted = append(sorted, dagId)
+	}
+	sort.Strings(sorted)
+	previousStart := input.Start.Add(-input.End.Sub(input.Start))
+	analytics := RunAnalytics{Dags: []RunTrend{}}
+	var all []airflow.DAGRun
+	for _, dagId := range sorted {
+		runs, err := cli.ListDagRuns(ListDagRunsInput{
+			DagId:      dagId,
+			StartDate:  previousStart,
+			EndDate:    input.End,
+			NoBackfill: input.NoBackfill,
+		})
+		if err != nil {
+			return RunAnalytics{}, err
+		}
+		all = append(all, runs...)
+		analytics.Dags = append(analytics.Dags, CompareRunStats(
+			ComputeRunStats(dagId, runs, input.Start, input.End),
+			ComputeRunStats(dagId, runs, previousStart, input.Start),
+		))
+	}
+	analytics.Total = CompareRunStats(
+		ComputeRunStats("", all, input.Start, input.End),
+		ComputeRunStats("", all, previousStart, input.Start),
+	)
+	return analytics, nil
+}
+
+// writes one csv row per trend with a header row, durations in seconds
+func WriteRunTrendsCSV(w io.Writer, trends []RunTrend) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{
+		"dag_id", "start", "end", "total", "success", "failed", "running", "queued", "success_rate",
+		"mean_duration", "p50_duration", "p95_duration", "max_duration",
+		"previous_total", "previous_success_rate", "previous_mean_duration", "previous_p95_duration",
+		"success_rate_delta", "mean_duration_delta", "p95_duration_delta",
+	})
+	if err != nil {
+		return err
+	}
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	}
+	rate := func(r float64) string {
+		return strconv.FormatFloat(r, 'f', 4, 64)
+	}
+	for _, t := range trends {
+		c, p := t.Current, t.Previous
+		err := cw.Write([]string{
+			c.DagId,
+			c.Start.Format(time.RFC3339),
+			c.End.Format(time.RFC3339),
+			strconv.Itoa(c.Total),
+			strconv.Itoa(c.ByState[string(airflow.DAGSTATE_SUCCESS)]),
+			strconv.Itoa(c.ByState[string(airflow.DAGSTATE_FAILED)]),
+			strconv.Itoa(c.ByState[string(airflow.DAGSTATE_RUNNING)]),
+			strconv.Itoa(c.ByState[string(airflow.DAGSTATE_QUEUED)]),
+			rate(c.SuccessRate),
+			seconds(c.MeanDuration),
+			seconds(c.P50Duration),
+			seconds(c.P95Duration),
+			seconds(c.MaxDuration),
+			strconv.Itoa(p.Total),
+			rate(p.SuccessRate),
+			seconds(p.MeanDuration),
+			seconds(p.P95Duration),
+			rate(t.SuccessRateDelta),
+			seconds(t.MeanDurationDelta),
+			seconds(t.P95DurationDelta),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
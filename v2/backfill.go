@@ -0,0 +1,288 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// args for Backfill
+type BackfillInput struct {
+	// required
+	DagId string
+	// required, logical dates to backfill, both included
+	StartDate time.Time
+	EndDate   time.Time
+	// only run tasks matching this regular expression
+	TaskRegex string
+	// delete existing dag runs and task instances in the range first
+	ResetDagRuns bool
+	// rerun failed tasks instead of failing on them
+	RerunFailedTasks bool
+	// run the latest logical dates first
+	RunBackwards bool
+	// keep going when a dag run fails, airflow 2.3+
+	ContinueOnFailures bool
+	Conf               map[string]interface{}
+	// list the task instances that would run without running them
+	DryRun bool
+}
+
+/*
+Backfill runs `dags backfill` for a date range
+
+The command runs to completion in the cli call, long backfills are better done with ChunkedBackfill.
+
+@return MWAAData - the backfill output
+*/
+func (cli *CLIENT) Backfill(input BackfillInput) (MWAAData, error) {
+	// airflow dags backfill [-h] [-c CONF] [--continue-on-failures] [--delay-on-limit DELAY_ON_LIMIT] [-x] [-n] [-e END_DATE] [-i] [-I] [-l] [-m] [--pool POOL]
+	// [--rerun-failed-tasks] [--reset-dagruns] [-B] [-s START_DATE] [-S SUBDIR] [-t TASK_REGEX] [-v] [-y] dag_id
+	if input.DagId == "" {
+		return MWAAData{}, errors.New("BackfillInput.DagId is empty, please provide a DagId")
+	}
+	if input.StartDate.IsZero() || input.EndDate.IsZero() {
+		return MWAAData{}, errors.New("BackfillInput.StartDate and EndDate are required")
+	}
+	if input.EndDate.Before(input.StartDate) {
+		return MWAAData{}, errors.New("BackfillInput.EndDate is before StartDate")
+	}
+	cmd := `dags backfill`
+	cmd += fmt.Sprintf(` --start-date '%s'`, input.StartDate.Format(PythonISONoDecimalTimeLayout))
+	cmd += fmt.Sprintf(` --end-date '%s'`, input.EndDate.Format(PythonISONoDecimalTimeLayout))
+	if input.TaskRegex != "" {
+		cmd += fmt.Sprintf(` --task-regex '%s'`, input.TaskRegex)
+	}
+	if input.ResetDagRuns {
+		// --yes skips the confirmation prompt
+		cmd += ` --reset-dagruns --yes`
+	}
+	if input.RerunFailedTasks {
+		cmd += ` --rerun-failed-tasks`
+	}
+	if input.RunBackwards {
+		cmd += ` --run-backwards`
+	}
+	if input.ContinueOnFailures {
+		cmd += ` --continue-on-failures`
+	}
+	if input.Conf != nil {
+		conf, err := json.Marshal(input.Conf)
+		if err != nil {
+			return MWAAData{}, errors.New("error marshaling BackfillInput.Conf")
+		}
+		cmd += fmt.Sprintf(` --conf '%s'`, conf)
+	}
+	if input.DryRun {
+		cmd += ` --dry-run`
+	}
+	cmd += fmt.Sprintf(` '%s'`, input.DagId)
+	return PostMWAACommand(cli, cmd)
+}
+
+// args for ChunkedBackfill
+type ChunkedBackfillInput struct {
+	// required
+	DagId string
+	// logical dates from StartDate to EndDate, both included, Step apart
+	StartDate time.Time
+	EndDate   time.Time
+	// defaults to 24h
+	Step time.Duration
+	// logical dates to backfill, used instead of StartDate, EndDate and Step when set
+	Dates []time.Time
+	Conf  map[string]interface{}
+	// dag runs in flight at once, defaults to 1
+	Concurrency int
+	// file progress is kept in, a backfill started again with the same file resumes where it stopped
+	Checkpoint string
+	Wait       DagRunWaitOptions
+}
+
+// outcome of one logical date of a chunked backfill
+type BackfillRunResult struct {
+	LogicalDate time.Time        `json:"logical_date"`
+	RunId       string           `json:"run_id,omitempty"`
+	State       airflow.DagState `json:"state,omitempty"`
+	FailedTasks []string         `json:"failed_tasks,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// done tells whether the logical date needs no more work
+func (r BackfillRunResult) done() bool {
+	return isTerminalDagState(r.State)
+}
+
+type BackfillSummary struct {
+	DagId string `json:"dag_id"`
+	// sorted by logical date
+	Runs      []BackfillRunResult `json:"runs"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	// logical dates that could not be triggered or waited on, retried when resumed
+	Errored int `json:"errored"`
+	// logical dates finished before a resume
+	Resumed int `json:"resumed"`
+}
+
+// the state of a chunked backfill, keyed by logical date in RFC3339
+type BackfillCheckpoint struct {
+	DagId string                       `json:"dag_id"`
+	Runs  map[string]BackfillRunResult `json:"runs"`
+}
+
+// returns the logical dates of input, sorted
+func (input ChunkedBackfillInput) dates() ([]time.Time, error) {
+	if len(input.Dates) > 0 {
+		dates := append([]time.Time{}, input.Dates...)
+		sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		return dates, nil
+	}
+	if input.StartDate.IsZero() || input.EndDate.IsZero() {
+		return nil, errors.New("ChunkedBackfillInput.StartDate and EndDate, or Dates, are required")
+	}
+	step := input.Step
+	if step <= 0 {
+		step = 24 * time.Hour
+	}
+	var dates []time.Time
+	for d := input.StartDate; !d.After(input.EndDate); d = d.Add(step) {
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
+
+func loadBackfillCheckpoint(path string, dagId string) (BackfillCheckpoint, error) {
+	checkpoint := BackfillCheckpoint{DagId: dagId, Runs: map[string]BackfillRunResult{}}
+	if path == "" {
+		return checkpoint, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	} else if err != nil {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid backfill checkpoint %s: %w", path, err)
+	}
+	if checkpoint.DagId != dagId {
+		return checkpoint, fmt.Errorf("backfill checkpoint %s is for %s, not %s", path, checkpoint.DagId, dagId)
+	}
+	if checkpoint.Runs == nil {
+		checkpoint.Runs = map[string]BackfillRunResult{}
+	}
+	return checkpoint, nil
+}
+
+/*
+ChunkedBackfill backfills a DAG by triggering one dag run per logical date, rather than with a single long `dags backfill`
+
+At most Concurrency runs are in flight at once, each is waited on before the next logical date is triggered.
+Progress is saved to Checkpoint after every change; started again with the same Checkpoint, finished logical dates are skipped and runs still in flight are waited on rather than triggered again.
+Runs are triggered with TriggerDagRunOnce, so a run triggered just before a crash, and missing from Checkpoint, is also waited on rather than failing as a duplicate.
+Stops triggering when ctx is done.
+
+@return BackfillSummary - the outcome of every logical date
+*/
+func (cli *CLIENT) ChunkedBackfill(ctx context.Context, input ChunkedBackfillInput) (BackfillSummary, error) {
+	summary := BackfillSummary{DagId: input.DagId, Runs: []BackfillRunResult{}}
+	if input.DagId == "" {
+		return summary, errors.New("ChunkedBackfillInput.DagId is empty, please provide a DagId")
+	}
+	dates, err := input.dates()
+	if err != nil {
+		return summary, err
+	}
+	checkpoint, err := loadBackfillCheckpoint(input.Checkpoint, input.DagId)
+	if err != nil {
+		return summary, err
+	}
+	var mu sync.Mutex
+	var saveErr error
+	record := func(r BackfillRunResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		checkpoint.Runs[r.LogicalDate.UTC().Format(time.RFC3339)] = r
+		if input.Checkpoint == "" {
+			return
+		}
+		data, err := json.MarshalIndent(checkpoint, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(input.Checkpoint, data)
+		}
+		if err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+	results := make([]BackfillRunResult, len(dates))
+	resumed := make([]bool, len(dates))
+	concurrency := input.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	forEachLimit(len(dates), concurrency, func(i int) {
+		mu.Lock()
+		r, seen := checkpoint.Runs[dates[i].UTC().Format(time.RFC3339)]
+		mu.Unlock()
+		if seen && r.done() {
+			results[i], resumed[i] = r, true
+			return
+		}
+		r = BackfillRunResult{LogicalDate: dates[i], RunId: r.RunId}
+		if ctx.Err() != nil {
+			r.Error = ctx.Err().Error()
+			results[i] = r
+			return
+		}
+		if r.RunId == "" {
+			// the run id follows from the logical date and conf, so a run triggered but not recorded before a crash is found again
+			run, _, err := cli.TriggerDagRunOnce(TriggerOnceInput{DagId: input.DagId, LogicalDate: dates[i], Conf: input.Conf})
+			if err != nil {
+				r.Error = err.Error()
+				results[i] = r
+				record(r)
+				return
+			}
+			r.RunId = run.GetDagRunId()
+			record(r)
+		}
+		wait, err := cli.WaitForDagRun(ctx, input.DagId, r.RunId, input.Wait)
+		r.State = wait.Run.GetState()
+		r.FailedTasks = wait.FailedTasks
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results[i] = r
+		record(r)
+	})
+	for i, r := range results {
+		summary.Runs = append(summary.Runs, r)
+		switch {
+		case resumed[i]:
+			summary.Resumed++
+			fallthrough
+		case r.done():
+			if r.State == airflow.DAGSTATE_SUCCESS {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+		default:
+			summary.Errored++
+		}
+	}
+	if saveErr != nil {
+		return summary, saveErr
+	}
+	return summary, ctx.Err()
+}
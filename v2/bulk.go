@@ -0,0 +1,151 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// returned by PauseDags and UnpauseDags for a zero DagQuery, which would match every DAG, unless BulkOptions.All is set
+var ErrEmptySelector = errors.New("selector is empty and would match every DAG, set BulkOptions.All to act on every DAG")
+
+// actions reported by bulk operations
+const (
+	ActionPause   = "pause"
+	ActionUnpause = "unpause"
+	// the DAG was already in the target state
+	ActionSkip = "skip"
+)
+
+// optional args for bulk operations
+type BulkOptions struct {
+	// commands in flight at once, defaults to 4
+	Concurrency int
+	// resolve and report the affected DAGs without changing anything
+	DryRun bool
+	// allow an empty selector, acting on every DAG of the environment
+	All bool
+}
+
+// outcome of a bulk operation for one DAG
+type DagActionResult struct {
+	DagId  string `json:"dag_id"`
+	Action string `json:"action"`
+	DryRun bool   `json:"dry_run,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BulkResult struct {
+	Results   []DagActionResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Skipped   int               `json:"skipped"`
+}
+
+// calls fn for every index below n, with at most concurrency calls running at once
+func forEachLimit(n int, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// pauses or unpauses a DAG
+func (cli *CLIENT) setPaused(dagId string, paused bool) error {
+	// airflow dags pause|unpause [-h] [-S SUBDIR] dag_id
+	cmd := fmt.Sprintf(`dags unpause '%s'`, dagId)
+	if paused {
+		cmd = fmt.Sprintf(`dags pause '%s'`, dagId)
+	}
+	_, err := PostMWAACommand(cli, cmd)
+	return err
+}
+
+// sets the paused state of every DAG in targets, keyed by dag id, leaving DAGs already in their target state alone
+// current holds the paused state each DAG is in now
+func (cli *CLIENT) applyPausedStates(current map[string]bool, targets map[string]bool, dagIds []string, opts BulkOptions) BulkResult {
+	result := BulkResult{Results: make([]DagActionResult, len(dagIds))}
+	forEachLimit(len(dagIds), opts.Concurrency, func(i int) {
+		dagId := dagIds[i]
+		paused := targets[dagId]
+		r := DagActionResult{DagId: dagId, Action: ActionUnpause, DryRun: opts.DryRun}
+		if paused {
+			r.Action = ActionPause
+		}
+		if was, ok := current[dagId]; ok && was == paused {
+			r.Action = ActionSkip
+		} else if !opts.DryRun {
+			if err := cli.setPaused(dagId, paused); err != nil {
+				r.Error = err.Error()
+			}
+		}
+		result.Results[i] = r
+	})
+	for _, r := range result.Results {
+		switch {
+		case r.Error != "":
+			result.Failed++
+		case r.Action == ActionSkip:
+			result.Skipped++
+		default:
+			result.Succeeded++
+		}
+	}
+	return result
+}
+
+func (cli *CLIENT) setDagsPaused(selector DagQuery, paused bool, opts BulkOptions) (BulkResult, error) {
+	if selector == (DagQuery{}) && !opts.All {
+		return BulkResult{}, ErrEmptySelector
+	}
+	dags, err := cli.QueryDags(selector)
+	if err != nil {
+		return BulkResult{}, err
+	}
+	current := map[string]bool{}
+	targets := map[string]bool{}
+	dagIds := make([]string, len(dags))
+	for i, d := range dags {
+		dagIds[i] = d.DagId
+		current[d.DagId] = d.Paused
+		targets[d.DagId] = paused
+	}
+	return cli.applyPausedStates(current, targets, dagIds, opts), nil
+}
+
+/*
+PauseDags pauses every DAG matching selector
+
+DAGs that are already paused are reported as skipped. With DryRun set nothing is changed and the result previews the affected DAGs.
+An empty selector returns ErrEmptySelector unless opts.All is set.
+
+@return BulkResult - one result per matched DAG
+*/
+func (cli *CLIENT) PauseDags(selector DagQuery, opts BulkOptions) (BulkResult, error) {
+	return cli.setDagsPaused(selector, true, opts)
+}
+
+/*
+UnpauseDags unpauses every DAG matching selector
+
+DAGs that are already unpaused are reported as skipped. With DryRun set nothing is changed and the result previews the affected DAGs.
+An empty selector returns ErrEmptySelector unless opts.All is set.
+
+@return BulkResult - one result per matched DAG
+*/
+func (cli *CLIENT) UnpauseDags(selector DagQuery, opts BulkOptions) (BulkResult, error) {
+	return cli.setDagsPaused(selector, false, opts)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// optional args for TriggerDagRuns
+type BulkTriggerOptions struct {
+	// triggers in flight at once, defaults to 4
+	Concurrency int
+	// at most this many triggers per second, 0 for no limit
+	RatePerSecond float64
+	// wait for every triggered run to succeed or fail
+	Wait bool
+	// poll intervals used when Wait is set, Progress is not called
+	WaitOptions DagRunWaitOptions
+}
+
+// outcome of one spec of a bulk trigger
+type BulkTriggerResult struct {
+	Spec airflow.DAGRun `json:"spec"`
+	// the triggered run, as last polled when waiting
+	Run         airflow.DAGRun `json:"run"`
+	Triggered   bool           `json:"triggered"`
+	FailedTasks []string       `json:"failed_tasks,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+type BulkTriggerSummary struct {
+	// in the order of the specs
+	Results       []BulkTriggerResult `json:"results"`
+	Triggered     int                 `json:"triggered"`
+	TriggerFailed int                 `json:"trigger_failed"`
+	// set when waiting
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// triggered runs still queued or running when the wait stopped
+	Unfinished int `json:"unfinished"`
+}
+
+// spaces out calls to wait so at most one returns per interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// blocks until the caller's turn, or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval <= 0 || ctx.Err() != nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
+
+/*
+TriggerDagRuns triggers a dag run per spec, e.g. the same DAG once per partition with a different Conf
+
+A failed trigger is recorded in its result and the other specs carry on.
+When opts.Wait is set the triggered runs are then polled together, with one `dags list-runs` per DAG per poll, until every run succeeds or fails or ctx is done.
+A run missing from the listing for several polls in a row, e.g. deleted, is left unfinished with an Error.
+
+@param specs []airflow.DAGRun - passed to NewDagRun as is
+@return BulkTriggerSummary - a result per spec and the counts of each outcome
+*/
+func (cli *CLIENT) TriggerDagRuns(ctx context.Context, specs []airflow.DAGRun, opts BulkTriggerOptions) (BulkTriggerSummary, error) {
+	summary := BulkTriggerSummary{Results: make([]BulkTriggerResult, len(specs))}
+	limiter := newRateLimiter(opts.RatePerSecond)
+	forEachLimit(len(specs), opts.Concurrency, func(i int) {
+		r := BulkTriggerResult{Spec: specs[i]}
+		if err := limiter.wait(ctx); err != nil {
+			r.Error = err.Error()
+			summary.Results[i] = r
+			return
+		}
+		run, err := cli.NewDagRun(specs[i])
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Run, r.Triggered = *run, true
+		}
+		summary.Results[i] = r
+	})
+	var err error
+	if opts.Wait {
+		err = cli.waitForTriggeredRuns(ctx, summary.Results, opts.WaitOptions)
+	}
+	for _, r := range summary.Results {
+		if !r.Triggered {
+			summary.TriggerFailed++
+			continue
+		}
+		summary.Triggered++
+		if !opts.Wait {
+			continue
+		}
+		switch r.Run.GetState() {
+		case airflow.DAGSTATE_SUCCESS:
+			summary.Succeeded++
+		case airflow.DAGSTATE_FAILED:
+			summary.Failed++
+		default:
+			summary.Unfinished++
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return summary, err
+}
+
+// polls in a row a triggered run may be missing from `dags list-runs` before it is given up on, e.g. deleted
+const missingRunPolls = 3
+
+// polls the triggered runs of results until each succeeds or fails, or is no longer listed, updating results in place
+func (cli *CLIENT) waitForTriggeredRuns(ctx context.Context, results []BulkTriggerResult, opts DagRunWaitOptions) error {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	// polls in a row each run was not listed
+	missing := make([]int, len(results))
+	for {
+		// indexes of the unfinished runs, by dag id
+		pending := map[string][]int{}
+		for i, r := range results {
+			if r.Triggered && !isTerminalDagState(r.Run.GetState()) && missing[i] < missingRunPolls {
+				pending[r.Run.GetDagId()] = append(pending[r.Run.GetDagId()], i)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = opts.next(interval)
+		for dagId, indexes := range pending {
+			// the runs waited on are listed from the earliest of their logical dates
+			var since time.Time
+			for _, i := range indexes {
+				if date := runLogicalDate(results[i].Run); since.IsZero() || date.Before(since) {
+					since = date
+				}
+			}
+			runs, err := cli.ListDagRuns(ListDagRunsInput{DagId: dagId, StartDate: since})
+			if err != nil {
+				return err
+			}
+			for _, i := range indexes {
+				runId := results[i].Run.GetDagRunId()
+				run, found := GetDagByRunId(runs, runId)
+				if !found {
+					if missing[i]++; missing[i] == missingRunPolls {
+						results[i].Error = fmt.Sprintf("dag run %s of %s is no longer listed", runId, dagId)
+					}
+					continue
+				}
+				missing[i] = 0
+				results[i].Run = run
+				if run.GetState() == airflow.DAGSTATE_FAILED {
+					tasks, err := cli.GetTaskStatesDetailed(dagId, airflow.NullableTime{}, *airflow.NewNullableString(&runId))
+					if err != nil {
+						results[i].Error = err.Error()
+						continue
+					}
+					results[i].FailedTasks = failedTasks(tasks)
+				}
+			}
+		}
+	}
+}
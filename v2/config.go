@@ -0,0 +1,198 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/mwaa"
+)
+
+// airflow configuration overrides set on the environment, keyed "section.option", e.g. "core.parallelism"
+type ConfigOverrides map[string]string
+
+// a change of one override's value
+type ConfigChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// difference between the current and desired overrides
+type ConfigDiff struct {
+	Added   map[string]string       `json:"added,omitempty"`
+	Changed map[string]ConfigChange `json:"changed,omitempty"`
+	Removed map[string]string       `json:"removed,omitempty"`
+}
+
+// an override next to the value airflow actually resolves for it
+type ConfigReportEntry struct {
+	Key       string `json:"key"`
+	Override  string `json:"override"`
+	Effective string `json:"effective"`
+	Matches   bool   `json:"matches"`
+}
+
+type ConfigReport []ConfigReportEntry
+
+// options MWAA manages itself and does not allow overriding
+var MWAAReservedConfigOptions = map[string]bool{
+	"core.dags_folder":               true,
+	"core.executor":                  true,
+	"core.fernet_key":                true,
+	"core.plugins_folder":            true,
+	"core.sql_alchemy_conn":          true,
+	"database.sql_alchemy_conn":      true,
+	"celery.broker_url":              true,
+	"celery.result_backend":          true,
+	"logging.base_log_folder":        true,
+	"logging.remote_base_log_folder": true,
+	"logging.remote_log_conn_id":     true,
+	"logging.remote_logging":         true,
+	"webserver.base_url":             true,
+	"webserver.secret_key":           true,
+	"webserver.web_server_port":      true,
+	"webserver.web_server_ssl_cert":  true,
+	"webserver.web_server_ssl_key":   true,
+}
+
+var configKeyRegexp = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_]+$`)
+
+// true if nothing differs
+func (d ConfigDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// returns what would change going from current to desired
+func DiffConfigOverrides(current ConfigOverrides, desired ConfigOverrides) ConfigDiff {
+	diff := ConfigDiff{
+		Added:   map[string]string{},
+		Changed: map[string]ConfigChange{},
+		Removed: map[string]string{},
+	}
+	for k, v := range desired {
+		old, ok := current[k]
+		if !ok {
+			diff.Added[k] = v
+		} else if old != v {
+			diff.Changed[k] = ConfigChange{From: old, To: v}
+		}
+	}
+	for k, v := range current {
+		if _, ok := desired[k]; !ok {
+			diff.Removed[k] = v
+		}
+	}
+	return diff
+}
+
+// returns an error listing every key that is malformed or that MWAA does not allow overriding
+func ValidateConfigOverrides(overrides ConfigOverrides) error {
+	var invalid []string
+	for k := range overrides {
+		if !configKeyRegexp.MatchString(k) {
+			invalid = append(invalid, fmt.Sprintf("'%s' is not of the form section.option", k))
+		} else if MWAAReservedConfigOptions[k] {
+			invalid = append(invalid, fmt.Sprintf("'%s' cannot be overridden on MWAA", k))
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return errors.New("invalid configuration overrides:\n" + strings.Join(invalid, "\n"))
+	}
+	return nil
+}
+
+// returns the airflow configuration overrides currently set on the environment
+func (cli *CLIENT) GetConfigOverrides(ctx context.Context) (ConfigOverrides, error) {
+	env, err := cli.GetEnvironment(ctx)
+	if err != nil {
+		return ConfigOverrides{}, err
+	}
+	overrides := ConfigOverrides{}
+	for k, v := range env.AirflowConfigurationOptions {
+		overrides[k] = aws.StringValue(v)
+	}
+	return overrides, nil
+}
+
+/*
+ApplyConfigOverrides replaces the environment's configuration overrides with desired and waits for the update to finish
+
+Nothing is sent when desired matches the current overrides.
+
+@param desired ConfigOverrides - the complete set of overrides the environment should have; keys missing from it are removed.
+
+@return ConfigDiff - what was changed
+*/
+func (cli *CLIENT) ApplyConfigOverrides(ctx context.Context, desired ConfigOverrides, opts EnvironmentWaitOptions) (ConfigDiff, error) {
+	if err := ValidateConfigOverrides(desired); err != nil {
+		return ConfigDiff{}, err
+	}
+	current, err := cli.GetConfigOverrides(ctx)
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+	diff := DiffConfigOverrides(current, desired)
+	if diff.IsEmpty() {
+		return diff, nil
+	}
+	if opts.Since.IsZero() {
+		opts.Since = time.Now()
+	}
+	err = cli.UpdateEnvironment(ctx, mwaa.UpdateEnvironmentInput{AirflowConfigurationOptions: aws.StringMap(desired)})
+	if err != nil {
+		return diff, err
+	}
+	_, err = cli.WaitForEnvironment(ctx, opts)
+	return diff, err
+}
+
+// returns the value airflow resolves for a "section.option" key
+func (cli *CLIENT) GetConfigValue(key string) (string, error) {
+	// airflow config get-value [-h] section option
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("'%s' is not of the form section.option", key)
+	}
+	cmd := fmt.Sprintf(`config get-value '%s' '%s'`, parts[0], parts[1])
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(data.StdoutStr, "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
+
+// returns each override on the environment next to its effective value
+func (cli *CLIENT) GetConfigReport(ctx context.Context) (ConfigReport, error) {
+	overrides, err := cli.GetConfigOverrides(ctx)
+	if err != nil {
+		return ConfigReport{}, err
+	}
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	report := ConfigReport{}
+	for _, k := range keys {
+		effective, err := cli.GetConfigValue(k)
+		if err != nil {
+			return report, err
+		}
+		report = append(report, ConfigReportEntry{
+			Key:       k,
+			Override:  overrides[k],
+			Effective: effective,
+			Matches:   effective == overrides[k],
+		})
+	}
+	return report, nil
+}
@@ -61,15 +61,19 @@ type DagJobsInput struct {
 	Limit airflow.NullableInt    `json:"limit,omitempty"`
 }
 
-// returned from: `airflow dags report`
-type DagReport []struct {
-	File     string   `json:"file,omitempty"`
-	Duration string   `json:"duration,omitempty"`
-	DagNum   string   `json:"dag_num,omitempty"`
-	TaskNum  string   `json:"task_num,omitempty"`
-	Dags     []string `json:"dags,omitempty"`
+// one file of `airflow dags report`
+type DagReportEntry struct {
+	File string `json:"file,omitempty"`
+	// time taken to parse the file
+	Duration time.Duration `json:"duration,omitempty"`
+	DagNum   int           `json:"dag_num,omitempty"`
+	TaskNum  int           `json:"task_num,omitempty"`
+	Dags     []string      `json:"dags,omitempty"`
 }
 
+// returned from: `airflow dags report`
+type DagReport []DagReportEntry
+
 // string version of airflow.DAGRun
 type DAGRunString []struct {
 	DagFields dagFields
@@ -133,7 +137,11 @@ func UnmarshalDagDiGraph(data MWAAData) (string, error) {
 	var diGraph string
 	err := json.Unmarshal(data.Stdout, &diGraph)
 	if err != nil {
-		return "", err
+		// `dags show` prints the digraph as is
+		if !strings.HasPrefix(strings.TrimSpace(data.StdoutStr), "digraph") {
+			return "", err
+		}
+		return strings.TrimSpace(data.StdoutStr), nil
 	}
 	return diGraph, nil
 }
@@ -176,35 +184,44 @@ func (cli *CLIENT) DeleteConnection(connectionId string) error {
 	return nil
 }
 
-// returns all dag runs
+// returns all dag runs of every DAG, with one `dags list-runs` per DAG, see GetDagRuns
 func (cli *CLIENT) GetAllDagRuns() ([]airflow.DAGRun, error) {
 	dagRun := airflow.NewDAGRun()
 	return cli.GetDagRuns(*dagRun)
 }
 
-// returns dagruns that match dagRun
+// returns dagruns that match dagRun's DagId, DagRunId, State and LogicalDate or ExecutionDate, runs on or after that date
+// without a DagId the runs of every DAG are searched, that is a `dags list` and then one `dags list-runs` per DAG, one after the other,
+// so set a DagId, or use ListDagRuns, where the DAG is known
 func (cli *CLIENT) GetDagRuns(dagRun airflow.DAGRun) ([]airflow.DAGRun, error) {
-	cmd := `dags list-runs`
-	// Override start_date in format YYYY-MM-DD
-	if dagRun.HasStartDate() {
-		cmd += fmt.Sprintf(` --start-date '%s'`, dagRun.GetLogicalDate().Format(time.Layout))
-	}
-	cmd += ` --output json`
-	data, err := PostMWAACommand(cli, cmd)
-	if err != nil {
-		return []airflow.DAGRun{}, err
-	}
-	dagRuns, err := UnmarshalDagRuns(data)
-	if err != nil {
-		return []airflow.DAGRun{}, err
+	input := ListDagRunsInput{DagId: dagRun.GetDagId(), RunId: dagRun.GetDagRunId(), State: dagRun.GetState()}
+	if dagRun.HasLogicalDate() {
+		input.StartDate = dagRun.GetLogicalDate()
+	} else if dagRun.HasExecutionDate() {
+		input.StartDate = dagRun.GetExecutionDate()
+	}
+	dagIds := []string{input.DagId}
+	if input.DagId == "" {
+		dags, err := cli.ListDags()
+		if err != nil {
+			return []airflow.DAGRun{}, err
+		}
+		dagIds = make([]string, len(dags))
+		for i, d := range dags {
+			dagIds[i] = d.DagId
+		}
 	}
-	if dagRun.HasDagId() {
-		foundDagRun, found := GetDagByRunId(dagRuns, dagRun.GetDagRunId())
-		if !found {
-			return []airflow.DAGRun{}, errors.New("found no dag with runId: " + dagRun.GetDagRunId())
-		} else {
-			return []airflow.DAGRun{foundDagRun}, nil
+	dagRuns := []airflow.DAGRun{}
+	for _, dagId := range dagIds {
+		input.DagId = dagId
+		runs, err := cli.ListDagRuns(input)
+		if err != nil {
+			return []airflow.DAGRun{}, err
 		}
+		dagRuns = append(dagRuns, runs...)
+	}
+	if input.RunId != "" && len(dagRuns) == 0 {
+		return []airflow.DAGRun{}, errors.New("found no dag with runId: " + input.RunId)
 	}
 	return dagRuns, nil
 }
@@ -228,6 +245,9 @@ func (cli *CLIENT) NewDagRun(dagRun airflow.DAGRun) (*airflow.DAGRun, error) {
 	if dagRun.GetDagId() == "" {
 		return &airflow.DAGRun{}, errors.New("DagRun.DagId is empty, please provide a DagId")
 	}
+	if err := cli.validateConf(dagRun.GetDagId(), dagRun.GetConf()); err != nil {
+		return &airflow.DAGRun{}, err
+	}
 	if dagRun.HasConf() {
 		jsonStr, err := json.Marshal(dagRun.GetConf())
 		if err != nil {
@@ -251,15 +271,23 @@ func (cli *CLIENT) NewDagRun(dagRun airflow.DAGRun) (*airflow.DAGRun, error) {
 	// airflow dags trigger does not use --output flag
 	data, err := PostMWAACommand(cli, cmd)
 	if err != nil {
+		if isDagRunConflict(data.StderrStr) {
+			return &airflow.DAGRun{}, fmt.Errorf("%w: %s", ErrDagRunExists, err)
+		}
 		fmt.Printf("\n%+v", data)
 		return &airflow.DAGRun{}, err
 	}
 	newDagRun, err := ParseNewDagRun(data)
-	newDagRun.SetConf(*dagRun.Conf)
 	if err != nil {
+		if isDagRunConflict(data.StderrStr) {
+			return &airflow.DAGRun{}, fmt.Errorf("%w: %s", ErrDagRunExists, data.StderrStr)
+		}
 		return &airflow.DAGRun{}, err
 	}
-	return &newDagRun, err
+	if dagRun.HasConf() {
+		newDagRun.SetConf(dagRun.GetConf())
+	}
+	return &newDagRun, nil
 }
 
 // Returns all DAGs
@@ -267,13 +295,9 @@ func (cli *CLIENT) GetDags() (Dags, error) {
 	cmd := `dags list --output json`
 	data, err := PostMWAACommand(cli, cmd)
 	if err != nil {
-		panic(err)
-	}
-	dags, err := UnmarshalGetDags(data)
-	if err != nil {
-		panic(err)
+		return Dags{}, err
 	}
-	return dags, err
+	return UnmarshalGetDags(data)
 }
 
 // pause a DAG
@@ -429,14 +453,59 @@ func (cli *CLIENT) GetDagJobs(i DagJobsInput) (DagJobs, error) {
 	return UnmarshalGetDagJobs(data)
 }
 
-// execute one single DagRun for a given DAG and execution date, using the DebugExecutor.
-// func (cli *CLIENT) DagTest(dagId string, executionDate time.Time) (MWAAData, error) {
-// 	// airflow dags test [-h] [--imgcat-dagrun] [--save-dagrun SAVE_DAGRUN]
-// 	// [--show-dagrun] [-S SUBDIR]
-// 	// dag_id execution_date
-// 	cmd := fmt.Sprintf(`dags test '%s' '%s'`, dagId, executionDate.Format(PythonISONoDecimalTimeLayout))
-// 	return PostMWAACommand(cli, cmd)
-// }
+// args for DagTest
+type DagTestInput struct {
+	// required
+	DagId string
+	// defaults to now, leaving it out needs airflow 2.5+
+	ExecutionDate time.Time
+	// airflow 2.5+
+	Conf map[string]interface{}
+}
+
+/*
+DagTest executes one single DagRun for a given DAG and execution date, without the scheduler, and parses its log
+
+A run whose tasks fail is not an error, see DagTestResult.Succeeded and DagTestResult.FailedTasks.
+
+@return DagTestResult - the run and task outcomes, with the raw output
+*/
+func (cli *CLIENT) DagTest(input DagTestInput) (DagTestResult, error) {
+	// airflow dags test [-h] [-c CONF] [--imgcat-dagrun] [--save-dagrun SAVE_DAGRUN]
+	// [--show-dagrun] [-S SUBDIR]
+	// dag_id [execution_date]
+	if input.DagId == "" {
+		return DagTestResult{}, errors.New("DagTestInput.DagId is empty, please provide a DagId")
+	}
+	cmd := `dags test`
+	if input.Conf != nil {
+		conf, err := json.Marshal(input.Conf)
+		if err != nil {
+			return DagTestResult{}, errors.New("error marshaling DagTestInput.Conf")
+		}
+		cmd += fmt.Sprintf(` --conf '%s'`, conf)
+	}
+	cmd += fmt.Sprintf(` '%s'`, input.DagId)
+	if !input.ExecutionDate.IsZero() {
+		cmd += fmt.Sprintf(` '%s'`, input.ExecutionDate.Format(PythonISONoDecimalTimeLayout))
+	} else if err := cli.Supports("dags test", "[execution_date]"); err != nil {
+		return DagTestResult{}, err
+	}
+	data, err := PostMWAACommand(cli, cmd)
+	// failing tasks may log airflow exceptions, which only fail the command when nothing ran
+	result := ParseDagTestLog(data.StdoutStr + "\n" + data.StderrStr)
+	if err != nil && len(result.Tasks) == 0 {
+		return DagTestResult{}, err
+	}
+	result.Output = data
+	if result.DagId == "" {
+		result.DagId = input.DagId
+	}
+	if result.LogicalDate.IsZero() {
+		result.LogicalDate = input.ExecutionDate
+	}
+	return result, nil
+}
 
 // find a dagRun by dagId
 func GetDagByRunId(dags []airflow.DAGRun, runId string) (airflow.DAGRun, bool) {
@@ -0,0 +1,301 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// where MWAA syncs the DagS3Path folder to
+const MWAADagsFolder = "/usr/local/airflow/dags"
+
+// returns p relative to the dags folder, e.g. "team/example_dag.py" for "/usr/local/airflow/dags/team/example_dag.py" and for "/team/example_dag.py"
+func dagsRelativePath(p string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(path.Clean("/"+p), MWAADagsFolder+"/"), "/")
+}
+
+// columns requested from `dags list` when the airflow version supports --columns
+var dagListColumns = []string{"dag_id", "fileloc", "owners", "is_paused", "tags", "schedule_interval", "is_active"}
+
+// a DAG as listed by `airflow dags list`
+type DAG struct {
+	DagId string `json:"dag_id"`
+	// path as reported by airflow
+	FilePath string `json:"filepath"`
+	// path relative to the dags folder, e.g. "team/example_dag.py"
+	File   string   `json:"file"`
+	Owners []string `json:"owners"`
+	Paused bool     `json:"paused"`
+	// the following are only listed on airflow versions supporting `dags list --columns`
+	Tags     []string `json:"tags,omitempty"`
+	Schedule string   `json:"schedule,omitempty"`
+	Active   *bool    `json:"active,omitempty"`
+}
+
+// bools may be json bools or python's "True"/"False"
+func unmarshalLooseBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(s)
+}
+
+// returns the first field of raw present under any of keys
+func firstField(raw map[string]json.RawMessage, keys ...string) (json.RawMessage, bool) {
+	for _, k := range keys {
+		if v, ok := raw[k]; ok && string(v) != "null" {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (d *DAG) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*d = DAG{}
+	if v, ok := raw["dag_id"]; ok {
+		if err := json.Unmarshal(v, &d.DagId); err != nil {
+			return err
+		}
+	}
+	if v, ok := firstField(raw, "filepath", "fileloc"); ok {
+		if err := json.Unmarshal(v, &d.FilePath); err != nil {
+			return err
+		}
+		d.File = dagsRelativePath(d.FilePath)
+	}
+	if v, ok := firstField(raw, "owners", "owner"); ok {
+		owners, err := unmarshalLooseStrings(v)
+		if err != nil {
+			return err
+		}
+		// `dags list` joins owners with ", "
+		d.Owners = splitOwners(strings.Join(owners, ","))
+	}
+	if v, ok := firstField(raw, "paused", "is_paused"); ok {
+		paused, err := unmarshalLooseBool(v)
+		if err != nil {
+			return err
+		}
+		d.Paused = paused
+	}
+	if v, ok := firstField(raw, "is_active"); ok {
+		active, err := unmarshalLooseBool(v)
+		if err != nil {
+			return err
+		}
+		d.Active = &active
+	}
+	if v, ok := firstField(raw, "tags"); ok {
+		// tags are either names or {"name": ...} objects
+		var objects []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(v, &objects); err == nil {
+			for _, o := range objects {
+				d.Tags = append(d.Tags, o.Name)
+			}
+		} else if d.Tags, err = unmarshalLooseStrings(v); err != nil {
+			return err
+		}
+	}
+	if v, ok := firstField(raw, "schedule_interval", "timetable_description", "schedule"); ok {
+		// either a plain string or {"__type": "CronExpression", "value": "0 0 * * *"}
+		var schedule struct {
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(v, &d.Schedule); err != nil {
+			if err := json.Unmarshal(v, &schedule); err != nil {
+				return err
+			}
+			d.Schedule = schedule.Value
+		}
+	}
+	return nil
+}
+
+func UnmarshalListDags(data MWAAData) ([]DAG, error) {
+	var dags []DAG
+	err := json.Unmarshal(data.Stdout, &dags)
+	if err != nil {
+		return []DAG{}, err
+	}
+	return dags, nil
+}
+
+// returns every DAG, with tags, schedule and active flag where the airflow version can list them
+func (cli *CLIENT) ListDags() ([]DAG, error) {
+	// airflow dags list [-h] [--columns COLUMNS] [-o table, json, yaml, plain] [-S SUBDIR] [-v]
+	cmd := `dags list`
+	if cli.SupportsFlag(`dags list`, `--columns`) {
+		cmd += fmt.Sprintf(` --columns '%s'`, strings.Join(dagListColumns, ","))
+	}
+	cmd += ` --output json`
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return []DAG{}, err
+	}
+	return UnmarshalListDags(data)
+}
+
+// filters for ListDags output, empty fields match everything
+type DagQuery struct {
+	// regular expression matched against the dag id
+	IdRegex string
+	// matches DAGs with this among their owners
+	Owner string
+	// matches DAGs carrying this tag
+	Tag string
+	// matches DAGs in this paused state
+	Paused *bool
+	// path.Match pattern matched against the path relative to the dags folder, e.g. "team/*.py"
+	FileGlob string
+	// matches DAGs whose path relative to the dags folder starts with this, e.g. "team/"
+	FilePrefix string
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the DAGs matching every set field of q
+func FilterDags(dags []DAG, q DagQuery) ([]DAG, error) {
+	var idRegexp *regexp.Regexp
+	if q.IdRegex != "" {
+		var err error
+		if idRegexp, err = regexp.Compile(q.IdRegex); err != nil {
+			return []DAG{}, err
+		}
+	}
+	if q.FileGlob != "" {
+		if _, err := path.Match(q.FileGlob, ""); err != nil {
+			return []DAG{}, err
+		}
+	}
+	matched := []DAG{}
+	for _, d := range dags {
+		if idRegexp != nil && !idRegexp.MatchString(d.DagId) {
+			continue
+		}
+		if q.Owner != "" && !contains(d.Owners, q.Owner) {
+			continue
+		}
+		if q.Tag != "" && !contains(d.Tags, q.Tag) {
+			continue
+		}
+		if q.Paused != nil && d.Paused != *q.Paused {
+			continue
+		}
+		if q.FileGlob != "" {
+			if ok, _ := path.Match(q.FileGlob, d.File); !ok {
+				continue
+			}
+		}
+		if q.FilePrefix != "" && !strings.HasPrefix(d.File, strings.TrimPrefix(q.FilePrefix, "/")) {
+			continue
+		}
+		matched = append(matched, d)
+	}
+	return matched, nil
+}
+
+// returns the DAGs on the environment matching q
+func (cli *CLIENT) QueryDags(q DagQuery) ([]DAG, error) {
+	dags, err := cli.ListDags()
+	if err != nil {
+		return []DAG{}, err
+	}
+	return FilterDags(dags, q)
+}
+
+// field to sort DAGs by
+type DagSortKey string
+
+const (
+	SortByDagId  DagSortKey = "dag_id"
+	SortByFile   DagSortKey = "file"
+	SortByOwner  DagSortKey = "owner"
+	SortByPaused DagSortKey = "paused"
+)
+
+// sorts dags in place by key, ties broken by dag id
+func SortDags(dags []DAG, key DagSortKey, descending bool) {
+	field := func(d DAG) string {
+		switch key {
+		case SortByFile:
+			return d.File
+		case SortByOwner:
+			return strings.Join(d.Owners, ",")
+		case SortByPaused:
+			return strconv.FormatBool(d.Paused)
+		}
+		return d.DagId
+	}
+	sort.SliceStable(dags, func(i, j int) bool {
+		a, b := field(dags[i]), field(dags[j])
+		if a == b {
+			a, b = dags[i].DagId, dags[j].DagId
+		}
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+// writes dags as a json array
+func WriteDagsJSON(w io.Writer, dags []DAG) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dags)
+}
+
+// writes dags as csv with a header row, list fields joined with ";"
+func WriteDagsCSV(w io.Writer, dags []DAG) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"dag_id", "file", "owners", "paused", "tags", "schedule", "active"})
+	if err != nil {
+		return err
+	}
+	for _, d := range dags {
+		active := ""
+		if d.Active != nil {
+			active = strconv.FormatBool(*d.Active)
+		}
+		err := cw.Write([]string{
+			d.DagId,
+			d.File,
+			strings.Join(d.Owners, ";"),
+			strconv.FormatBool(d.Paused),
+			strings.Join(d.Tags, ";"),
+			d.Schedule,
+			active,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// run types, as prefixed to generated run ids
+const (
+	RunTypeManual           = "manual"
+	RunTypeScheduled        = "scheduled"
+	RunTypeBackfill         = "backfill"
+	RunTypeDatasetTriggered = "dataset_triggered"
+)
+
+const (
+	// `dags list-runs` takes dates as YYYY-MM-DD
+	listDagRunsDateLayout = "2006-01-02"
+	// python's str(datetime)
+	pythonStrTimeLayout = "2006-01-02 15:04:05.999999-07:00"
+)
+
+// optional args for ListDagRuns
+type ListDagRunsInput struct {
+	// required
+	DagId string
+	// only runs in this state
+	State airflow.DagState
+	// only runs with an execution date on or after this date, in UTC, the time of day is ignored
+	StartDate time.Time
+	// only runs with an execution date on or before the end of this date, in UTC, the time of day is ignored
+	EndDate time.Time
+	// leave out backfill runs
+	NoBackfill bool
+	// only the run with this run id
+	RunId string
+	// at most this many runs, newest first; 0 returns every run
+	Limit int
+}
+
+// parses the times `dags list-runs` prints, isoformat or str(datetime)
+func parseAirflowTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, pythonStrTimeLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unable to parse time: " + s)
+}
+
+// returns the run type encoded in a generated run id, e.g. "scheduled" for "scheduled__2022-12-01T00:00:00+00:00", empty for custom run ids
+func RunTypeOf(runId string) string {
+	prefix, _, found := strings.Cut(runId, "__")
+	if !found {
+		return ""
+	}
+	switch prefix {
+	case RunTypeManual, RunTypeScheduled, RunTypeBackfill, RunTypeDatasetTriggered:
+		return prefix
+	}
+	return ""
+}
+
+// returns the logical date encoded in a generated run id, e.g. 2022-12-01 for "scheduled__2022-12-01T00:00:00+00:00", zero for custom run ids
+func logicalDateOf(runId string) time.Time {
+	if RunTypeOf(runId) == "" {
+		return time.Time{}
+	}
+	_, date, _ := strings.Cut(runId, "__")
+	t, err := parseAirflowTime(date)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+/*
+UnmarshalListDagRuns parses `dags list-runs --output json`
+
+Times are parsed, the run type is taken from the output or else from the run id prefix, and the data interval is set when the airflow version prints it.
+*/
+func UnmarshalListDagRuns(data MWAAData) ([]airflow.DAGRun, error) {
+	var raw []map[string]interface{}
+	err := json.Unmarshal(data.Stdout, &raw)
+	if err != nil {
+		return []airflow.DAGRun{}, err
+	}
+	runs := []airflow.DAGRun{}
+	for _, fields := range raw {
+		str := func(key string) string {
+			if v, ok := fields[key].(string); ok && v != "None" {
+				return v
+			}
+			return ""
+		}
+		run := airflow.NewDAGRun()
+		run.SetDagId(str("dag_id"))
+		runId := str("run_id")
+		if runId == "" {
+			runId = str("dag_run_id")
+		}
+		run.SetDagRunId(runId)
+		if state := str("state"); state != "" {
+			run.SetState(airflow.DagState(state))
+		}
+		runType := str("run_type")
+		if runType == "" {
+			runType = RunTypeOf(runId)
+		}
+		if runType != "" {
+			run.SetRunType(runType)
+		}
+		if external, ok := fields["external_trigger"].(bool); ok {
+			run.SetExternalTrigger(external)
+		}
+		times := []struct {
+			key string
+			set func(time.Time)
+		}{
+			{"execution_date", run.SetExecutionDate},
+			{"logical_date", run.SetLogicalDate},
+			{"start_date", run.SetStartDate},
+			{"end_date", run.SetEndDate},
+			{"data_interval_start", run.SetDataIntervalStart},
+			{"data_interval_end", run.SetDataIntervalEnd},
+		}
+		for _, field := range times {
+			if s := str(field.key); s != "" {
+				t, err := parseAirflowTime(s)
+				if err != nil {
+					return []airflow.DAGRun{}, fmt.Errorf("%s of %s: %w", field.key, runId, err)
+				}
+				field.set(t)
+			}
+		}
+		runs = append(runs, *run)
+	}
+	return runs, nil
+}
+
+// returns the runs of a DAG matching input, newest first
+func (cli *CLIENT) ListDagRuns(input ListDagRunsInput) ([]airflow.DAGRun, error) {
+	// airflow dags list-runs [-h] -d DAG_ID [-e END_DATE] [--no-backfill] [-o table, json, yaml, plain] [-s START_DATE] [--state STATE] [-v]
+	if input.DagId == "" {
+		return []airflow.DAGRun{}, errors.New("ListDagRunsInput.DagId is empty, please provide a DagId")
+	}
+	cmd := fmt.Sprintf(`dags list-runs --dag-id '%s'`, input.DagId)
+	if input.State != "" {
+		if !input.State.IsValid() {
+			return []airflow.DAGRun{}, fmt.Errorf(`'%s' is not a valid DagState`, input.State)
+		}
+		cmd += fmt.Sprintf(` --state '%s'`, input.State)
+	}
+	if !input.StartDate.IsZero() {
+		cmd += fmt.Sprintf(` --start-date '%s'`, input.StartDate.UTC().Format(listDagRunsDateLayout))
+	}
+	if !input.EndDate.IsZero() {
+		// a bare date would be read as midnight, leaving out the rest of the day
+		end := input.EndDate.UTC().Truncate(24 * time.Hour).Add(24*time.Hour - time.Microsecond)
+		cmd += fmt.Sprintf(` --end-date '%s'`, end.Format(time.RFC3339Nano))
+	}
+	if input.NoBackfill {
+		cmd += ` --no-backfill`
+	}
+	cmd += ` --output json`
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return []airflow.DAGRun{}, err
+	}
+	runs, err := UnmarshalListDagRuns(data)
+	if err != nil {
+		return []airflow.DAGRun{}, err
+	}
+	return filterDagRuns(runs, input), nil
+}
+
+// applies the filters of input the cli has no flags for
+func filterDagRuns(runs []airflow.DAGRun, input ListDagRunsInput) []airflow.DAGRun {
+	if input.RunId != "" {
+		run, found := GetDagByRunId(runs, input.RunId)
+		if !found {
+			return []airflow.DAGRun{}
+		}
+		runs = []airflow.DAGRun{run}
+	}
+	if input.Limit > 0 && len(runs) > input.Limit {
+		runs = runs[:input.Limit]
+	}
+	return runs
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// optional args for WaitForDagRun
+type DagRunWaitOptions struct {
+	// time before the second poll, defaults to 10s
+	PollInterval time.Duration
+	// longest time between polls, defaults to 2m
+	MaxInterval time.Duration
+	// factor the interval grows by after each poll, defaults to 1.5; 1 polls at a fixed interval
+	Backoff float64
+	// called after every poll with the run and the state of each of its tasks
+	Progress func(run airflow.DAGRun, tasks []TaskStatesDetailed)
+}
+
+type DagRunWaitResult struct {
+	Run   airflow.DAGRun       `json:"run"`
+	Tasks []TaskStatesDetailed `json:"tasks"`
+	// ids of the tasks that failed, sorted
+	FailedTasks []string `json:"failed_tasks"`
+}
+
+// true for the states a dag run ends in
+func isTerminalDagState(state airflow.DagState) bool {
+	return state == airflow.DAGSTATE_SUCCESS || state == airflow.DAGSTATE_FAILED
+}
+
+// returns the next poll interval
+func (opts DagRunWaitOptions) next(interval time.Duration) time.Duration {
+	backoff := opts.Backoff
+	if backoff == 0 {
+		backoff = 1.5
+	}
+	if backoff < 1 {
+		backoff = 1
+	}
+	max := opts.MaxInterval
+	if max <= 0 {
+		max = 2 * time.Minute
+	}
+	interval = time.Duration(float64(interval) * backoff)
+	if interval > max {
+		return max
+	}
+	return interval
+}
+
+// returns the dag run runId of dagId, only listing runs from logicalDate on when it is set
+func (cli *CLIENT) getDagRun(dagId string, runId string, logicalDate time.Time) (airflow.DAGRun, error) {
+	runs, err := cli.ListDagRuns(ListDagRunsInput{DagId: dagId, RunId: runId, StartDate: logicalDate})
+	if err != nil {
+		return airflow.DAGRun{}, err
+	}
+	if len(runs) == 0 {
+		return airflow.DAGRun{}, fmt.Errorf("found no dag run of %s with runId: %s", dagId, runId)
+	}
+	return runs[0], nil
+}
+
+/*
+WaitForDagRun polls a dag run until it succeeds or fails
+
+The interval between polls starts at PollInterval and grows by Backoff up to MaxInterval.
+Stops early, returning ctx.Err(), when ctx is cancelled or its deadline passes.
+
+@param runId string - e.g. the DagRunId returned by NewDagRun
+
+@return DagRunWaitResult - the final run, its task states and the ids of the tasks that failed
+*/
+func (cli *CLIENT) WaitForDagRun(ctx context.Context, dagId string, runId string, opts DagRunWaitOptions) (DagRunWaitResult, error) {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	result := DagRunWaitResult{FailedTasks: []string{}}
+	// bounds the polls once known, custom run ids are listed in full until first found
+	logicalDate := logicalDateOf(runId)
+	for {
+		run, err := cli.getDagRun(dagId, runId, logicalDate)
+		if err != nil {
+			return result, err
+		}
+		logicalDate = runLogicalDate(run)
+		result.Run = run
+		terminal := isTerminalDagState(run.GetState())
+		if opts.Progress != nil || terminal {
+			tasks, err := cli.GetTaskStatesDetailed(dagId, airflow.NullableTime{}, *airflow.NewNullableString(&runId))
+			if err != nil {
+				return result, err
+			}
+			result.Tasks = tasks
+			if opts.Progress != nil {
+				opts.Progress(run, tasks)
+			}
+		}
+		if terminal {
+			result.FailedTasks = failedTasks(result.Tasks)
+			return result, nil
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = opts.next(interval)
+	}
+}
+
+// returns the ids of the failed tasks, sorted
+func failedTasks(tasks []TaskStatesDetailed) []string {
+	failed := []string{}
+	for _, task := range tasks {
+		if task.State == string(airflow.TASKSTATE_FAILED) && task.TaskId != nil {
+			failed = append(failed, *task.TaskId)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
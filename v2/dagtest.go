@@ -0,0 +1,159 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+var (
+	// [2023-01-01T00:00:00.000+0000] {taskinstance.py:1327} INFO - message
+	logLineRegexp = regexp.MustCompile(`^\[[^\]]+\] \{[^}]+\} [A-Z]+ - (.*)$`)
+	// Marking task as SUCCESS. dag_id=example_dag, task_id=print_date, execution_date=20230101T000000, start_date=..., end_date=...
+	markingTaskRegexp = regexp.MustCompile(`^Marking task as ([A-Z_]+)\. (.*)$`)
+	// Marking run <DagRun example_dag @ 2023-01-01 00:00:00+00:00: manual__..., state:running, ...> successful
+	markingRunRegexp = regexp.MustCompile(`^Marking run <DagRun .*> (successful|failed)$`)
+)
+
+// the task times airflow logs when marking a task, e.g. 20230101T120000
+const taskLogTimeLayout = "20060102T150405"
+
+// outcome of one task of a `dags test` run
+type DagTestTask struct {
+	TaskId string            `json:"task_id"`
+	State  airflow.TaskState `json:"state"`
+	// zero when the log does not have them
+	StartDate time.Time     `json:"start_date"`
+	EndDate   time.Time     `json:"end_date"`
+	Duration  time.Duration `json:"duration"`
+	// last line of the traceback of the last failure, e.g. "ValueError: boom"
+	Exception string `json:"exception,omitempty"`
+	// times the task was marked, more than 1 when it was retried
+	Tries int `json:"tries"`
+}
+
+type DagTestResult struct {
+	DagId       string    `json:"dag_id"`
+	LogicalDate time.Time `json:"logical_date"`
+	// empty when the log does not say how the run ended
+	State airflow.DagState `json:"state"`
+	// in the order they were first marked
+	Tasks  []DagTestTask `json:"tasks"`
+	Output MWAAData      `json:"output"`
+}
+
+// true when the run, and every task, succeeded or was skipped
+func (r DagTestResult) Succeeded() bool {
+	if r.State == airflow.DAGSTATE_FAILED {
+		return false
+	}
+	for _, task := range r.Tasks {
+		if task.State != airflow.TASKSTATE_SUCCESS && task.State != airflow.TASKSTATE_SKIPPED {
+			return false
+		}
+	}
+	return r.State == airflow.DAGSTATE_SUCCESS || len(r.Tasks) > 0
+}
+
+// returns the tasks that failed, upstream failed or were left up for retry
+func (r DagTestResult) FailedTasks() []DagTestTask {
+	failed := []DagTestTask{}
+	for _, task := range r.Tasks {
+		switch task.State {
+		case airflow.TASKSTATE_FAILED, airflow.TASKSTATE_UPSTREAM_FAILED, airflow.TASKSTATE_UP_FOR_RETRY:
+			failed = append(failed, task)
+		}
+	}
+	return failed
+}
+
+// splits "a=1, b=2" into a map
+func parseLogFields(s string) map[string]string {
+	fields := map[string]string{}
+	for _, pair := range strings.Split(s, ", ") {
+		if key, value, found := strings.Cut(pair, "="); found {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+/*
+ParseDagTestLog parses the log of `dags test` into the outcome of every task
+
+Tasks are read from the "Marking task as ..." lines, exceptions from the traceback logged before a task is marked failed,
+and the run state from the "Marking run ... successful|failed" line.
+*/
+func ParseDagTestLog(log string) DagTestResult {
+	result := DagTestResult{Tasks: []DagTestTask{}}
+	index := map[string]int{}
+	collecting := false
+	var exception, pendingException string
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimRight(line, "\r")
+		match := logLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			// traceback lines, the exception is the last unindented one
+			if collecting && line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") &&
+				!strings.HasPrefix(line, "Traceback") && !strings.HasPrefix(line, "The above exception") && !strings.HasPrefix(line, "During handling") {
+				exception = line
+			}
+			continue
+		}
+		if collecting {
+			collecting, pendingException = false, exception
+		}
+		message := match[1]
+		if strings.HasPrefix(message, "Task failed with exception") || strings.HasPrefix(message, "Failed to execute job") {
+			collecting, exception = true, ""
+			continue
+		}
+		if m := markingRunRegexp.FindStringSubmatch(message); m != nil {
+			result.State = airflow.DAGSTATE_SUCCESS
+			if m[1] == "failed" {
+				result.State = airflow.DAGSTATE_FAILED
+			}
+			continue
+		}
+		m := markingTaskRegexp.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		fields := parseLogFields(m[2])
+		taskId := fields["task_id"]
+		if taskId == "" {
+			continue
+		}
+		if result.DagId == "" {
+			result.DagId = fields["dag_id"]
+		}
+		if result.LogicalDate.IsZero() {
+			if t, err := time.Parse(taskLogTimeLayout, fields["execution_date"]); err == nil {
+				result.LogicalDate = t
+			}
+		}
+		i, seen := index[taskId]
+		if !seen {
+			i = len(result.Tasks)
+			index[taskId] = i
+			result.Tasks = append(result.Tasks, DagTestTask{TaskId: taskId})
+		}
+		task := &result.Tasks[i]
+		task.Tries++
+		task.State = airflow.TaskState(strings.ToLower(m[1]))
+		task.StartDate, _ = time.Parse(taskLogTimeLayout, fields["start_date"])
+		task.EndDate, _ = time.Parse(taskLogTimeLayout, fields["end_date"])
+		task.Duration = 0
+		if !task.StartDate.IsZero() && !task.EndDate.IsZero() {
+			task.Duration = task.EndDate.Sub(task.StartDate)
+		}
+		if task.State == airflow.TASKSTATE_FAILED || task.State == airflow.TASKSTATE_UP_FOR_RETRY {
+			task.Exception, pendingException = pendingException, ""
+		}
+	}
+	return result
+}
@@ -0,0 +1,323 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type DeployDagsInput struct {
+	// local directory mirrored to the environment's DagS3Path
+	Dir string
+	// where the environment's source bucket is reached
+	Store ObjectStore
+	// remove objects under DagS3Path that have no local counterpart
+	Delete bool
+	// skip waiting for airflow to parse the uploaded files
+	NoWait bool
+	// how long to wait for uploaded files to be parsed, defaults to 10m
+	Timeout time.Duration
+	// time between `dags report` calls, defaults to 30s
+	PollInterval time.Duration
+}
+
+type DeployDagsResult struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// paths relative to Dir / DagS3Path
+	Uploaded  []string `json:"uploaded,omitempty"`
+	Deleted   []string `json:"deleted,omitempty"`
+	Unchanged []string `json:"unchanged,omitempty"`
+	// uploaded dag files that did not parse into any DAG
+	Unparsed []string `json:"unparsed,omitempty"`
+	// import errors of the unparsed files
+	ImportErrors []ImportError `json:"import_errors,omitempty"`
+}
+
+// mirrors airflow's safe mode heuristic: only files mentioning both "airflow" and "dag" are parsed for DAGs
+func mightContainDag(body []byte) bool {
+	lower := bytes.ToLower(body)
+	return bytes.Contains(lower, []byte("airflow")) && bytes.Contains(lower, []byte("dag"))
+}
+
+// reads every deployable file under dir, keyed by slash separated relative path
+func readDagsDir(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "__pycache__" || (p != dir && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// .airflowignore is honored by the scheduler, other dotfiles are local clutter
+		if (strings.HasPrefix(d.Name(), ".") && d.Name() != ".airflowignore") || strings.HasSuffix(d.Name(), ".pyc") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		body, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = body
+		return nil
+	})
+	return files, err
+}
+
+/*
+DeployDags syncs a local directory to the environment's DAG folder in S3 and waits for airflow to parse the uploaded files
+
+Only files whose checksum differs from the object in S3 are uploaded.
+Unless NoWait is set, DeployDags polls `dags report` until every uploaded dag file is parsed, and fails with their import errors if any of them parsed into no DAGs.
+A new file is parsed once it is listed. A modified file is already listed from its old version, so it only counts as parsed once
+its DAGs, task count or import error differ from before the upload, or, on airflow 2.8.1+, once its DAGs were last parsed after the upload.
+Modified files that change none of those time out on older versions.
+
+@return DeployDagsResult - the files uploaded, deleted and left alone, and any that failed to parse
+*/
+func (cli *CLIENT) DeployDags(ctx context.Context, input DeployDagsInput) (DeployDagsResult, error) {
+	result := DeployDagsResult{}
+	if input.Store == nil {
+		return result, errors.New("DeployDagsInput.Store is nil, please provide an ObjectStore")
+	}
+	env, err := cli.GetEnvironment(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Bucket, err = BucketFromArn(aws.StringValue(env.SourceBucketArn))
+	if err != nil {
+		return result, err
+	}
+	result.Prefix = strings.Trim(aws.StringValue(env.DagS3Path), "/")
+	local, err := readDagsDir(input.Dir)
+	if err != nil {
+		return result, err
+	}
+	remote, err := input.Store.ListObjects(ctx, result.Bucket, result.Prefix+"/")
+	if err != nil {
+		return result, err
+	}
+	remoteETags := map[string]string{}
+	for _, o := range remote {
+		remoteETags[strings.TrimPrefix(o.Key, result.Prefix+"/")] = o.ETag
+	}
+
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var baseline map[string]dagFileState
+	if !input.NoWait {
+		// what airflow reports for the files now, so modified files are only taken as parsed once that changes
+		if baseline, err = cli.dagFileStates(names); err != nil {
+			return result, err
+		}
+	}
+	uploadedAt := time.Now()
+	var expected []string
+	for _, name := range names {
+		body := local[name]
+		if etag, ok := remoteETags[name]; ok && etag == ContentETag(body) {
+			result.Unchanged = append(result.Unchanged, name)
+			continue
+		}
+		if _, err := input.Store.PutObject(ctx, result.Bucket, path.Join(result.Prefix, name), body); err != nil {
+			return result, err
+		}
+		result.Uploaded = append(result.Uploaded, name)
+		if strings.HasSuffix(name, ".py") && mightContainDag(body) {
+			expected = append(expected, name)
+		}
+	}
+	if input.Delete {
+		for _, o := range remote {
+			name := strings.TrimPrefix(o.Key, result.Prefix+"/")
+			if _, ok := local[name]; ok || strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+			if err := input.Store.DeleteObject(ctx, result.Bucket, o.Key); err != nil {
+				return result, err
+			}
+			result.Deleted = append(result.Deleted, name)
+		}
+	}
+	if input.NoWait || len(expected) == 0 {
+		return result, nil
+	}
+	result.Unparsed, err = cli.waitForDagFiles(ctx, expected, baseline, uploadedAt, input.Timeout, input.PollInterval)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Unparsed) == 0 {
+		return result, nil
+	}
+	importErrors, err := cli.GetImportErrors()
+	if err != nil && !isUnavailable(err) {
+		return result, err
+	}
+	var msgs []string
+	for _, name := range result.Unparsed {
+		msg := name
+		for _, e := range importErrors {
+			if e.IsFor(name) {
+				result.ImportErrors = append(result.ImportErrors, e)
+				msg += ":\n" + e.Error
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return result, fmt.Errorf("dag files failed to parse:\n%s", strings.Join(msgs, "\n"))
+}
+
+// what airflow reports for a dag file
+type dagFileState struct {
+	Listed bool
+	// sorted
+	Dags        []string
+	TaskNum     int
+	ImportError string
+}
+
+// returns the state of every file in report and importErrors
+func dagFileStateOf(report DagReport, importErrors []ImportError, name string) dagFileState {
+	state := dagFileState{Dags: []string{}}
+	if entry, found := findReportFile(report, name); found {
+		state.Listed = true
+		state.Dags = append(state.Dags, entry.Dags...)
+		sort.Strings(state.Dags)
+		state.TaskNum = entry.TaskNum
+	}
+	for _, e := range importErrors {
+		if e.IsFor(name) {
+			state.ImportError = e.Error
+		}
+	}
+	return state
+}
+
+// returns the current state of files, keyed by name
+func (cli *CLIENT) dagFileStates(files []string) (map[string]dagFileState, error) {
+	report, err := cli.DagsReport()
+	if err != nil {
+		return nil, err
+	}
+	importErrors, err := cli.GetImportErrors()
+	if err != nil && !isUnavailable(err) {
+		return nil, err
+	}
+	states := map[string]dagFileState{}
+	for _, name := range files {
+		states[name] = dagFileStateOf(report, importErrors, name)
+	}
+	return states, nil
+}
+
+/*
+pendingDagFiles sorts uploaded files by what airflow reports for them now
+
+A file is parsed once it is listed or has an import error when it had neither before the upload,
+or once its state differs from before, or parsedAfterUpload says its DAGs were parsed since the upload.
+
+@return []string - files not parsed yet
+@return []string - parsed files without any DAGs
+*/
+func pendingDagFiles(files []string, baseline map[string]dagFileState, current map[string]dagFileState, parsedAfterUpload func(dags []string) bool) ([]string, []string) {
+	pending, unparsed := []string{}, []string{}
+	for _, name := range files {
+		before, now := baseline[name], current[name]
+		existed := before.Listed || before.ImportError != ""
+		parsed := now.Listed || now.ImportError != ""
+		if existed {
+			parsed = !reflect.DeepEqual(before, now) || (len(now.Dags) > 0 && parsedAfterUpload(now.Dags))
+		}
+		switch {
+		case !parsed:
+			pending = append(pending, name)
+		case now.ImportError != "" || len(now.Dags) == 0:
+			unparsed = append(unparsed, name)
+		}
+	}
+	return pending, unparsed
+}
+
+// returns when airflow last parsed the file of dagId, from `dags details`, airflow 2.8.1+
+func (cli *CLIENT) dagLastParsed(dagId string) (time.Time, error) {
+	data, err := PostMWAACommand(cli, fmt.Sprintf(`dags details '%s' --output json`, dagId))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var details []struct {
+		LastParsedTime string `json:"last_parsed_time"`
+	}
+	if err := json.Unmarshal(data.Stdout, &details); err != nil || len(details) != 1 {
+		return time.Time{}, errors.New("unable to parse dags details of " + dagId)
+	}
+	return parseAirflowTime(details[0].LastParsedTime)
+}
+
+// polls `dags report` until every file is parsed, returns the files parsed without any DAGs
+func (cli *CLIENT) waitForDagFiles(ctx context.Context, files []string, baseline map[string]dagFileState, uploadedAt time.Time, timeout time.Duration, interval time.Duration) ([]string, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	canDetail := cli.Supports("dags details") == nil
+	parsedAfterUpload := func(dags []string) bool {
+		if !canDetail {
+			return false
+		}
+		parsed, err := cli.dagLastParsed(dags[0])
+		return err == nil && parsed.After(uploadedAt)
+	}
+	for {
+		current, err := cli.dagFileStates(files)
+		if err != nil {
+			return []string{}, err
+		}
+		pending, unparsed := pendingDagFiles(files, baseline, current, parsedAfterUpload)
+		if len(pending) == 0 {
+			return unparsed, nil
+		}
+		select {
+		case <-ctx.Done():
+			return append(unparsed, pending...), fmt.Errorf("timed out waiting for dag files to be parsed:\n%s", strings.Join(pending, "\n"))
+		case <-time.After(interval):
+		}
+	}
+}
+
+// finds the report entry for a file path relative to the dags folder
+func findReportFile(report DagReport, name string) (DagReportEntry, bool) {
+	for _, entry := range report {
+		if dagsRelativePath(entry.File) == dagsRelativePath(name) {
+			return entry, true
+		}
+	}
+	return DagReportEntry{}, false
+}
@@ -0,0 +1,195 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"sort"
+	"strings"
+)
+
+// a DAG the environment is expected to contain
+type ExpectedDag struct {
+	DagId string `json:"dag_id"`
+	// optional, path relative to the dags folder, e.g. "team/example_dag.py"
+	FilePath string `json:"file_path,omitempty"`
+	// optional, compared as a set
+	Owners []string `json:"owners,omitempty"`
+	// optional, 0 skips the check
+	TaskCount int `json:"task_count,omitempty"`
+}
+
+// a DAG defined in a different file than expected
+type DagMove struct {
+	DagId    string `json:"dag_id"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// a DAG with a different number of tasks than expected
+type TaskCountChange struct {
+	DagId    string `json:"dag_id"`
+	Expected int    `json:"expected"`
+	Actual   int    `json:"actual"`
+}
+
+// a DAG with different owners than expected
+type OwnersChange struct {
+	DagId    string   `json:"dag_id"`
+	Expected []string `json:"expected"`
+	Actual   []string `json:"actual"`
+}
+
+// difference between the expected and the deployed DAGs
+type DriftReport struct {
+	Missing          []string          `json:"missing"`
+	Unexpected       []string          `json:"unexpected"`
+	Moved            []DagMove         `json:"moved"`
+	TaskCountChanged []TaskCountChange `json:"task_count_changed"`
+	OwnersChanged    []OwnersChange    `json:"owners_changed"`
+}
+
+// true if the environment differs from what was expected in any way
+func (r DriftReport) HasDrift() bool {
+	return len(r.Missing) > 0 || len(r.Unexpected) > 0 || len(r.Moved) > 0 ||
+		len(r.TaskCountChanged) > 0 || len(r.OwnersChanged) > 0
+}
+
+// splits the comma separated owner of `dags list` into a sorted set
+func splitOwners(owner string) []string {
+	var owners []string
+	for _, o := range strings.Split(owner, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			owners = append(owners, o)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// true if file, relative to the dags folder, names the same file as actual, which may be absolute
+func sameDagFile(file string, actual string) bool {
+	file = strings.TrimPrefix(file, "/")
+	return strings.TrimPrefix(actual, "/") == file || strings.HasSuffix(actual, "/"+file)
+}
+
+/*
+CompareDags compares the expected DAGs against `dags list` and `dags report` output
+
+@param taskCounts map[string]int - task count per dag id, only consulted for expected DAGs with a TaskCount
+
+@return DriftReport - missing, unexpected, moved DAGs and DAGs whose owners or task count changed, each sorted by dag id
+*/
+func CompareDags(expected []ExpectedDag, dags Dags, report DagReport, taskCounts map[string]int) DriftReport {
+	drift := DriftReport{
+		Missing:          []string{},
+		Unexpected:       []string{},
+		Moved:            []DagMove{},
+		TaskCountChanged: []TaskCountChange{},
+		OwnersChanged:    []OwnersChange{},
+	}
+	// report paths are relative to the dags folder, prefer them to the absolute `dags list` paths
+	reportFiles := map[string]string{}
+	for _, entry := range report {
+		for _, dagId := range entry.Dags {
+			reportFiles[dagId] = entry.File
+		}
+	}
+	deployed := map[string]int{}
+	for i, d := range dags {
+		deployed[d.DagId] = i
+	}
+	wanted := map[string]bool{}
+	for _, e := range expected {
+		wanted[e.DagId] = true
+		i, ok := deployed[e.DagId]
+		if !ok {
+			drift.Missing = append(drift.Missing, e.DagId)
+			continue
+		}
+		d := dags[i]
+		if e.FilePath != "" {
+			actual := d.FilePath
+			if f, ok := reportFiles[e.DagId]; ok {
+				actual = f
+			}
+			if !sameDagFile(e.FilePath, actual) {
+				drift.Moved = append(drift.Moved, DagMove{DagId: e.DagId, Expected: e.FilePath, Actual: actual})
+			}
+		}
+		if len(e.Owners) > 0 {
+			want := append([]string{}, e.Owners...)
+			sort.Strings(want)
+			got := splitOwners(d.Owner)
+			if strings.Join(want, ",") != strings.Join(got, ",") {
+				drift.OwnersChanged = append(drift.OwnersChanged, OwnersChange{DagId: e.DagId, Expected: want, Actual: got})
+			}
+		}
+		if e.TaskCount > 0 {
+			if got, ok := taskCounts[e.DagId]; ok && got != e.TaskCount {
+				drift.TaskCountChanged = append(drift.TaskCountChanged, TaskCountChange{DagId: e.DagId, Expected: e.TaskCount, Actual: got})
+			}
+		}
+	}
+	for _, d := range dags {
+		if !wanted[d.DagId] {
+			drift.Unexpected = append(drift.Unexpected, d.DagId)
+		}
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Unexpected)
+	sort.Slice(drift.Moved, func(i, j int) bool { return drift.Moved[i].DagId < drift.Moved[j].DagId })
+	sort.Slice(drift.TaskCountChanged, func(i, j int) bool {
+		return drift.TaskCountChanged[i].DagId < drift.TaskCountChanged[j].DagId
+	})
+	sort.Slice(drift.OwnersChanged, func(i, j int) bool { return drift.OwnersChanged[i].DagId < drift.OwnersChanged[j].DagId })
+	return drift
+}
+
+// compares the expected DAGs against what is deployed on the environment
+func (cli *CLIENT) CheckDagDrift(expected []ExpectedDag) (DriftReport, error) {
+	dags, err := cli.GetDags()
+	if err != nil {
+		return DriftReport{}, err
+	}
+	report, err := cli.DagsReport()
+	if err != nil {
+		return DriftReport{}, err
+	}
+	deployed := map[string]bool{}
+	for _, d := range dags {
+		deployed[d.DagId] = true
+	}
+	taskCounts := map[string]int{}
+	for _, e := range expected {
+		if e.TaskCount == 0 || !deployed[e.DagId] {
+			continue
+		}
+		// a file holding a single DAG reports that DAG's task count, avoiding a `tasks list` round trip
+		if entry, ok := reportEntryForDag(report, e.DagId); ok && len(entry.Dags) == 1 {
+			taskCounts[e.DagId] = entry.TaskNum
+			continue
+		}
+		tasks, err := cli.GetDagTasks(e.DagId)
+		if err != nil {
+			return DriftReport{}, err
+		}
+		unique := map[string]bool{}
+		for _, task := range tasks {
+			unique[*task.TaskId] = true
+		}
+		taskCounts[e.DagId] = len(unique)
+	}
+	return CompareDags(expected, dags, report, taskCounts), nil
+}
+
+// finds the report entry of the file defining dagId
+func reportEntryForDag(report DagReport, dagId string) (DagReportEntry, bool) {
+	for _, entry := range report {
+		for _, d := range entry.Dags {
+			if d == dagId {
+				return entry, true
+			}
+		}
+	}
+	return DagReportEntry{}, false
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/mwaa"
+)
+
+// optional args for WaitForEnvironment
+type EnvironmentWaitOptions struct {
+	// time between GetEnvironment calls, defaults to 30s
+	PollInterval time.Duration
+	// when the update being waited on was requested; AVAILABLE only counts once the update was seen to start
+	// or LastUpdate was created after Since. Zero accepts the first AVAILABLE
+	Since time.Time
+}
+
+// the MWAA environment calls the client makes, *mwaa.MWAA implements it
+type environmentAPI interface {
+	GetEnvironmentWithContext(ctx aws.Context, input *mwaa.GetEnvironmentInput, opts ...request.Option) (*mwaa.GetEnvironmentOutput, error)
+	UpdateEnvironmentWithContext(ctx aws.Context, input *mwaa.UpdateEnvironmentInput, opts ...request.Option) (*mwaa.UpdateEnvironmentOutput, error)
+}
+
+// returns the environmentAPI calls go to, the mwaa service unless one was set
+func (cli *CLIENT) environments() environmentAPI {
+	if cli.envAPI != nil {
+		return cli.envAPI
+	}
+	return &cli.svc
+}
+
+// returns the MWAA environment the client issues commands against
+func (cli *CLIENT) GetEnvironment(ctx context.Context) (*mwaa.Environment, error) {
+	out, err := cli.environments().GetEnvironmentWithContext(ctx, &mwaa.GetEnvironmentInput{Name: aws.String(*cli.Name)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Environment, nil
+}
+
+// sends an UpdateEnvironment request for this environment; input.Name is filled in
+func (cli *CLIENT) UpdateEnvironment(ctx context.Context, input mwaa.UpdateEnvironmentInput) error {
+	input.Name = aws.String(*cli.Name)
+	_, err := cli.environments().UpdateEnvironmentWithContext(ctx, &input)
+	return err
+}
+
+// true if the environment's last update was requested after since, allowing for clock skew
+func updatedSince(env *mwaa.Environment, since time.Time) bool {
+	if since.IsZero() || env.LastUpdate == nil || env.LastUpdate.CreatedAt == nil {
+		return false
+	}
+	// updates take minutes, so an earlier update cannot have been created within the skew allowance
+	return env.LastUpdate.CreatedAt.After(since.Add(-time.Minute))
+}
+
+// describes the environment's status and the error of its last update, if any
+func environmentError(name string, env *mwaa.Environment, status string) error {
+	msg := fmt.Sprintf("environment %s is %s", name, status)
+	if env.LastUpdate != nil && env.LastUpdate.Error != nil {
+		msg += fmt.Sprintf(": %s %s", aws.StringValue(env.LastUpdate.Error.ErrorCode), aws.StringValue(env.LastUpdate.Error.ErrorMessage))
+	}
+	return errors.New(msg)
+}
+
+/*
+WaitForEnvironment polls the environment until an update or creation finishes
+
+A failed update is rolled back by MWAA, which leaves the environment AVAILABLE with LastUpdate FAILED; that is returned as an error.
+Returns an error as well if the environment ends up in any other status, or ctx is done first.
+*/
+func (cli *CLIENT) WaitForEnvironment(ctx context.Context, opts EnvironmentWaitOptions) (*mwaa.Environment, error) {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	started := false
+	for {
+		env, err := cli.GetEnvironment(ctx)
+		if err != nil {
+			return nil, err
+		}
+		status := aws.StringValue(env.Status)
+		switch status {
+		case mwaa.EnvironmentStatusAvailable:
+			current := started || updatedSince(env, opts.Since)
+			if opts.Since.IsZero() || current {
+				if current && env.LastUpdate != nil && aws.StringValue(env.LastUpdate.Status) == mwaa.UpdateStatusFailed {
+					return env, environmentError(*cli.Name, env, "AVAILABLE after a failed update")
+				}
+				return env, nil
+			}
+			// still the AVAILABLE from before the update started
+		case mwaa.EnvironmentStatusCreating, mwaa.EnvironmentStatusUpdating,
+			mwaa.EnvironmentStatusRollingBack, mwaa.EnvironmentStatusCreatingSnapshot:
+			started = true
+		default:
+			return env, environmentError(*cli.Name, env, status)
+		}
+		select {
+		case <-ctx.Done():
+			return env, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,568 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var ErrDagCycle = errors.New("dag contains a cycle")
+
+// colors airflow renders task instance states with
+var stateColors = map[string]string{
+	"queued":            "gray",
+	"running":           "lime",
+	"success":           "green",
+	"restarting":        "violet",
+	"failed":            "red",
+	"up_for_retry":      "gold",
+	"up_for_reschedule": "turquoise",
+	"upstream_failed":   "orange",
+	"skipped":           "hotpink",
+	"removed":           "lightgrey",
+	"scheduled":         "tan",
+	"deferred":          "mediumpurple",
+}
+
+// a task in a DagGraph
+type DagGraphNode struct {
+	TaskId string `json:"task_id"`
+	Label  string `json:"label,omitempty"`
+	// operator class name, only known when merged from `tasks list`
+	Operator  string `json:"operator,omitempty"`
+	Color     string `json:"color,omitempty"`
+	FillColor string `json:"fill_color,omitempty"`
+	// task instance state, only known once states were applied, see ApplyStates and StatesFromColors
+	State string `json:"state,omitempty"`
+	// id of the innermost task group holding the task, empty outside of groups
+	Group string `json:"group,omitempty"`
+}
+
+// a dependency, From runs before To
+type DagEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// a task group in a DagGraph
+type DagGroup struct {
+	Id    string `json:"id"`
+	Label string `json:"label,omitempty"`
+	// id of the enclosing task group, empty for top level groups
+	Parent string `json:"parent,omitempty"`
+}
+
+// the tasks of a DAG and their dependencies, as rendered by `dags show`
+type DagGraph struct {
+	DagId string `json:"dag_id"`
+	// in the order `dags show` declares them
+	Nodes  []DagGraphNode `json:"nodes"`
+	Edges  []DagEdge      `json:"edges"`
+	Groups []DagGroup     `json:"groups"`
+}
+
+// splits DOT source into identifiers, quoted strings and punctuation
+func tokenizeDot(src string) ([]string, error) {
+	var tokens []string
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || (r == '/' && i+1 < len(runes) && runes[i+1] == '/'):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := strings.Index(string(runes[i+2:]), "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment in dot source")
+			}
+			i += 2 + len([]rune(string(runes[i+2:])[:end])) + 2
+		case r == '-' && i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '-'):
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		case strings.ContainsRune("{}[]=;,", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '"' {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated string in dot source")
+			}
+			i++
+			// quoted tokens are marked so keywords and punctuation can't be confused with them
+			tokens = append(tokens, "\x00"+b.String())
+		case r == '<':
+			depth, start := 0, i
+			for ; i < len(runes); i++ {
+				if runes[i] == '<' {
+					depth++
+				} else if runes[i] == '>' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated html string in dot source")
+			}
+			i++
+			tokens = append(tokens, "\x00"+string(runes[start+1:i-1]))
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("{}[]=;,\"#<", runes[i]) &&
+				!(runes[i] == '-' && i+1 < len(runes) && runes[i+1] == '>') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens, nil
+}
+
+type dotParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *dotParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *dotParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// true if t is an identifier or quoted string rather than punctuation
+func isDotId(t string) bool {
+	if strings.HasPrefix(t, "\x00") {
+		return true
+	}
+	switch t {
+	case "", "{", "}", "[", "]", "=", ";", ",", "->", "--":
+		return false
+	}
+	return true
+}
+
+func dotValue(t string) string {
+	return strings.TrimPrefix(t, "\x00")
+}
+
+// parses an attribute list, e.g. [color="#000000" label=task_1]
+func (p *dotParser) attrs() (map[string]string, error) {
+	attrs := map[string]string{}
+	for p.peek() == "[" {
+		p.next()
+		for p.peek() != "]" {
+			key := p.next()
+			if !isDotId(key) {
+				return nil, fmt.Errorf("unexpected '%s' in attribute list", dotValue(key))
+			}
+			value := "true"
+			if p.peek() == "=" {
+				p.next()
+				value = dotValue(p.next())
+			}
+			attrs[dotValue(key)] = value
+			if t := p.peek(); t == "," || t == ";" {
+				p.next()
+			}
+		}
+		p.next()
+	}
+	return attrs, nil
+}
+
+/*
+ParseDagGraph parses the Graphviz digraph printed by `dags show`
+
+Task group join nodes are collapsed, so the edges into and out of a group connect the tasks themselves.
+
+@param dot string - DagShow output
+
+@return *DagGraph
+*/
+func ParseDagGraph(dot string) (*DagGraph, error) {
+	tokens, err := tokenizeDot(dot)
+	if err != nil {
+		return nil, err
+	}
+	p := &dotParser{tokens: tokens}
+	if strings.EqualFold(p.peek(), "strict") {
+		p.next()
+	}
+	if !strings.EqualFold(p.next(), "digraph") {
+		return nil, errors.New("dot source is not a digraph")
+	}
+	g := &DagGraph{Nodes: []DagGraphNode{}, Edges: []DagEdge{}, Groups: []DagGroup{}}
+	if isDotId(p.peek()) {
+		g.DagId = dotValue(p.next())
+	}
+	if p.next() != "{" {
+		return nil, errors.New("expected '{' after digraph")
+	}
+	nodes := map[string]int{}
+	// innermost last
+	groups := []string{}
+	addNode := func(id string) *DagGraphNode {
+		if i, ok := nodes[id]; ok {
+			return &g.Nodes[i]
+		}
+		node := DagGraphNode{TaskId: id}
+		if len(groups) > 0 {
+			node.Group = groups[len(groups)-1]
+		}
+		nodes[id] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, node)
+		return &g.Nodes[len(g.Nodes)-1]
+	}
+	for {
+		t := p.next()
+		switch {
+		case t == "":
+			return nil, errors.New("unexpected end of dot source")
+		case t == ";" || t == "{":
+		case t == "}":
+			if len(groups) == 0 {
+				return g.collapseJoins(), nil
+			}
+			groups = groups[:len(groups)-1]
+		case t == "graph" || t == "node" || t == "edge":
+			attrs, err := p.attrs()
+			if err != nil {
+				return nil, err
+			}
+			if label, ok := attrs["label"]; ok && t == "graph" && len(groups) > 0 {
+				g.Groups[groupIndex(g.Groups, groups[len(groups)-1])].Label = label
+			}
+		case t == "subgraph":
+			name := ""
+			if isDotId(p.peek()) {
+				name = dotValue(p.next())
+			}
+			if p.next() != "{" {
+				return nil, fmt.Errorf("expected '{' after subgraph %s", name)
+			}
+			// airflow names a task group's cluster "cluster_<group_id>"
+			group := DagGroup{Id: strings.TrimPrefix(name, "cluster_")}
+			if len(groups) > 0 {
+				group.Parent = groups[len(groups)-1]
+			}
+			// subgraphs may be reopened
+			if groupIndex(g.Groups, group.Id) < 0 {
+				g.Groups = append(g.Groups, group)
+			}
+			groups = append(groups, group.Id)
+		case isDotId(t):
+			if p.peek() == "=" {
+				// graph attribute
+				p.next()
+				value := dotValue(p.next())
+				if dotValue(t) == "label" && len(groups) > 0 {
+					g.Groups[groupIndex(g.Groups, groups[len(groups)-1])].Label = value
+				}
+				continue
+			}
+			ids := []string{dotValue(t)}
+			for p.peek() == "->" || p.peek() == "--" {
+				p.next()
+				id := p.next()
+				if !isDotId(id) {
+					return nil, fmt.Errorf("unexpected '%s' in edge statement", dotValue(id))
+				}
+				ids = append(ids, dotValue(id))
+			}
+			attrs, err := p.attrs()
+			if err != nil {
+				return nil, err
+			}
+			if len(ids) == 1 {
+				node := addNode(ids[0])
+				node.Label = attrs["label"]
+				node.Color = attrs["color"]
+				node.FillColor = attrs["fillcolor"]
+				continue
+			}
+			for i := 0; i < len(ids)-1; i++ {
+				addNode(ids[i])
+				addNode(ids[i+1])
+				g.Edges = append(g.Edges, DagEdge{From: ids[i], To: ids[i+1]})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected '%s' in dot source", t)
+		}
+	}
+}
+
+// sets the State of every task from its fill color, only meaningful for graphs airflow rendered with task instance states,
+// plain `dags show` output colors tasks by operator
+func (g *DagGraph) StatesFromColors() {
+	for i := range g.Nodes {
+		for state, color := range stateColors {
+			if strings.EqualFold(color, g.Nodes[i].FillColor) {
+				g.Nodes[i].State = state
+			}
+		}
+	}
+}
+
+// returns the index of the group with id, -1 if there is none
+func groupIndex(groups []DagGroup, id string) int {
+	for i := range groups {
+		if groups[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// true for the nodes airflow draws where edges enter or leave a task group
+func isJoinNode(id string) bool {
+	return strings.HasSuffix(id, "upstream_join_id") || strings.HasSuffix(id, "downstream_join_id")
+}
+
+// replaces every join node with edges from its upstream to its downstream tasks
+func (g *DagGraph) collapseJoins() *DagGraph {
+	downstream, _ := g.adjacency()
+	seen := map[DagEdge]bool{}
+	edges := []DagEdge{}
+	addEdge := func(e DagEdge) {
+		if !seen[e] && !isJoinNode(e.From) && !isJoinNode(e.To) {
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+	// join nodes may chain when groups are nested
+	var resolve func(id string, next map[string][]string, visited map[string]bool) []string
+	resolve = func(id string, next map[string][]string, visited map[string]bool) []string {
+		if !isJoinNode(id) {
+			return []string{id}
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		var ids []string
+		for _, n := range next[id] {
+			ids = append(ids, resolve(n, next, visited)...)
+		}
+		return ids
+	}
+	for _, e := range g.Edges {
+		if isJoinNode(e.From) {
+			continue
+		}
+		for _, to := range resolve(e.To, downstream, map[string]bool{}) {
+			addEdge(DagEdge{From: e.From, To: to})
+		}
+	}
+	nodes := []DagGraphNode{}
+	for _, n := range g.Nodes {
+		if !isJoinNode(n.TaskId) {
+			nodes = append(nodes, n)
+		}
+	}
+	g.Nodes, g.Edges = nodes, edges
+	return g
+}
+
+// returns the direct downstream and upstream tasks of every task
+func (g *DagGraph) adjacency() (map[string][]string, map[string][]string) {
+	downstream := map[string][]string{}
+	upstream := map[string][]string{}
+	for _, e := range g.Edges {
+		downstream[e.From] = append(downstream[e.From], e.To)
+		upstream[e.To] = append(upstream[e.To], e.From)
+	}
+	return downstream, upstream
+}
+
+// returns the node for taskId
+func (g *DagGraph) Node(taskId string) (DagGraphNode, bool) {
+	for _, n := range g.Nodes {
+		if n.TaskId == taskId {
+			return n, true
+		}
+	}
+	return DagGraphNode{}, false
+}
+
+// returns the task ids in declaration order
+func (g *DagGraph) TaskIds() []string {
+	ids := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[i] = n.TaskId
+	}
+	return ids
+}
+
+// returns every task reachable from taskId by following next, sorted
+func closure(taskId string, next map[string][]string) []string {
+	seen := map[string]bool{taskId: true}
+	queue := []string{taskId}
+	ids := []string{}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, n := range next[id] {
+			if !seen[n] {
+				seen[n] = true
+				ids = append(ids, n)
+				queue = append(queue, n)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// returns every task taskId depends on, directly or not, sorted
+func (g *DagGraph) Upstream(taskId string) []string {
+	_, upstream := g.adjacency()
+	return closure(taskId, upstream)
+}
+
+// returns every task depending on taskId, directly or not, sorted; what clearing taskId with downstream would touch
+func (g *DagGraph) Downstream(taskId string) []string {
+	downstream, _ := g.adjacency()
+	return closure(taskId, downstream)
+}
+
+// returns the tasks without upstream tasks, in declaration order
+func (g *DagGraph) Roots() []string {
+	_, upstream := g.adjacency()
+	roots := []string{}
+	for _, n := range g.Nodes {
+		if len(upstream[n.TaskId]) == 0 {
+			roots = append(roots, n.TaskId)
+		}
+	}
+	return roots
+}
+
+// returns the tasks without downstream tasks, in declaration order
+func (g *DagGraph) Leaves() []string {
+	downstream, _ := g.adjacency()
+	leaves := []string{}
+	for _, n := range g.Nodes {
+		if len(downstream[n.TaskId]) == 0 {
+			leaves = append(leaves, n.TaskId)
+		}
+	}
+	return leaves
+}
+
+// returns the task ids so every task comes after its upstream tasks, ties kept in declaration order
+func (g *DagGraph) TopologicalOrder() ([]string, error) {
+	downstream, upstream := g.adjacency()
+	remaining := map[string]int{}
+	for _, n := range g.Nodes {
+		remaining[n.TaskId] = len(upstream[n.TaskId])
+	}
+	order := []string{}
+	done := map[string]bool{}
+	for len(order) < len(g.Nodes) {
+		progressed := false
+		for _, n := range g.Nodes {
+			if done[n.TaskId] || remaining[n.TaskId] > 0 {
+				continue
+			}
+			done[n.TaskId] = true
+			order = append(order, n.TaskId)
+			for _, d := range downstream[n.TaskId] {
+				remaining[d]--
+			}
+			progressed = true
+		}
+		if !progressed {
+			return order, g.Validate()
+		}
+	}
+	return order, nil
+}
+
+// returns an ErrDagCycle error naming the tasks of a cycle, if there is one
+func (g *DagGraph) Validate() error {
+	downstream, _ := g.adjacency()
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := map[string]int{}
+	var path []string
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		marks[id] = visiting
+		path = append(path, id)
+		for _, d := range downstream[id] {
+			if marks[d] == visiting {
+				for i, p := range path {
+					if p == d {
+						return append(append([]string{}, path[i:]...), d)
+					}
+				}
+			}
+			if marks[d] == 0 {
+				if cycle := visit(d); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		marks[id] = visited
+		return nil
+	}
+	for _, n := range g.Nodes {
+		if marks[n.TaskId] == 0 {
+			if cycle := visit(n.TaskId); cycle != nil {
+				return fmt.Errorf("%w: %s", ErrDagCycle, strings.Join(cycle, " -> "))
+			}
+		}
+	}
+	return nil
+}
+
+// returns the task graph of a DAG, with operators filled in from `tasks list`
+func (cli *CLIENT) GetDagGraph(dagId string) (*DagGraph, error) {
+	dot, err := cli.DagShow(dagId)
+	if err != nil {
+		return nil, err
+	}
+	g, err := ParseDagGraph(dot)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := cli.GetDagTasks(dagId)
+	if err != nil {
+		return nil, err
+	}
+	operators := map[string]string{}
+	for _, task := range tasks {
+		if task.TaskId != nil && task.Operator.IsSet() && task.Operator.Get() != nil {
+			operators[*task.TaskId] = *task.Operator.Get()
+		}
+	}
+	for i := range g.Nodes {
+		g.Nodes[i].Operator = operators[g.Nodes[i].TaskId]
+	}
+	return g, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// returned from: `airflow dags list-import-errors`
+type ImportError struct {
+	File  string `json:"filepath"`
+	Error string `json:"error"`
+}
+
+// a `dags report` file next to the import error airflow recorded for it, if any
+type FileParseStatus struct {
+	// path as listed by `dags report`, relative to the dags folder
+	File string   `json:"file"`
+	Dags []string `json:"dags,omitempty"`
+	// python traceback of the failed import
+	ImportError string `json:"import_error,omitempty"`
+}
+
+// true if the file imported without error
+func (s FileParseStatus) Parsed() bool {
+	return s.ImportError == ""
+}
+
+func UnmarshalImportErrors(data MWAAData) ([]ImportError, error) {
+	var importErrors []ImportError
+	err := json.Unmarshal(data.Stdout, &importErrors)
+	if err != nil {
+		return []ImportError{}, err
+	}
+	return importErrors, nil
+}
+
+// returns the import errors of every dag file that failed to parse
+func (cli *CLIENT) GetImportErrors() ([]ImportError, error) {
+	// airflow dags list-import-errors [-h] [-o table, json, yaml, plain] [-S SUBDIR] [-v]
+	cmd := `dags list-import-errors --output json`
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return []ImportError{}, err
+	}
+	// with no errors airflow prints "No data found" rather than an empty list
+	if !strings.HasPrefix(strings.TrimSpace(string(data.Stdout)), "[") {
+		return []ImportError{}, nil
+	}
+	return UnmarshalImportErrors(data)
+}
+
+// true if the import error belongs to file, a path relative to the dags folder; the whole path has to match, not only the file name
+func (e ImportError) IsFor(file string) bool {
+	return dagsRelativePath(e.File) == dagsRelativePath(file)
+}
+
+// pairs every `dags report` file with its import error
+// import errors for files missing from the report are appended as their own entries
+func CorrelateImportErrors(report DagReport, importErrors []ImportError) []FileParseStatus {
+	matched := make([]bool, len(importErrors))
+	var statuses []FileParseStatus
+	for _, entry := range report {
+		status := FileParseStatus{File: entry.File, Dags: entry.Dags}
+		for i, e := range importErrors {
+			if e.IsFor(entry.File) {
+				status.ImportError = e.Error
+				matched[i] = true
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	for i, e := range importErrors {
+		if !matched[i] {
+			statuses = append(statuses, FileParseStatus{File: e.File, ImportError: e.Error})
+		}
+	}
+	return statuses
+}
+
+// true if err means the environment cannot run the command at all
+func isUnavailable(err error) bool {
+	return errors.Is(err, ErrUnsupportedByVersion) || errors.Is(err, ErrCommandNotAllowed)
+}
+
+// a rollout check that fails on import errors not already present in baseline
+func importErrorsCheck(baseline []ImportError) RolloutCheck {
+	known := map[string]bool{}
+	for _, e := range baseline {
+		known[e.File+"\n"+e.Error] = true
+	}
+	return RolloutCheck{Name: "import errors", Run: func(cli *CLIENT) error {
+		importErrors, err := cli.GetImportErrors()
+		if isUnavailable(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msgs []string
+		for _, e := range importErrors {
+			if !known[e.File+"\n"+e.Error] {
+				msgs = append(msgs, fmt.Sprintf("%s:\n%s", e.File, e.Error))
+			}
+		}
+		if len(msgs) > 0 {
+			sort.Strings(msgs)
+			return errors.New("new import errors:\n" + strings.Join(msgs, "\n"))
+		}
+		return nil
+	}}
+}
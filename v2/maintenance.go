@@ -0,0 +1,185 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// paused state of every DAG recorded before maintenance
+type MaintenanceSnapshot struct {
+	Environment string    `json:"environment"`
+	CreatedAt   time.Time `json:"created_at"`
+	// paused state per dag id
+	Paused map[string]bool `json:"paused"`
+}
+
+type MaintenanceResult struct {
+	// path of the snapshot file
+	Snapshot string `json:"snapshot"`
+	// true if EnterMaintenance picked up a snapshot left by an earlier call
+	Resumed bool       `json:"resumed,omitempty"`
+	Bulk    BulkResult `json:"bulk"`
+	// DAGs listed now but missing from the snapshot
+	Appeared []string `json:"appeared"`
+	// DAGs in the snapshot but no longer listed
+	Vanished []string `json:"vanished"`
+}
+
+// reads a snapshot written by SaveMaintenanceSnapshot
+func LoadMaintenanceSnapshot(path string) (MaintenanceSnapshot, error) {
+	var snapshot MaintenanceSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("invalid maintenance snapshot %s: %w", path, err)
+	}
+	if snapshot.Paused == nil {
+		snapshot.Paused = map[string]bool{}
+	}
+	return snapshot, nil
+}
+
+// writes snapshot to path, replacing it atomically so a crash never leaves a partial file
+func SaveMaintenanceSnapshot(path string, snapshot MaintenanceSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writes data to a temporary file next to path, then renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// returns the listed DAGs missing from the snapshot and the snapshot DAGs no longer listed, each sorted
+func diffSnapshot(snapshot MaintenanceSnapshot, dags []DAG) ([]string, []string) {
+	appeared := []string{}
+	listed := map[string]bool{}
+	for _, d := range dags {
+		listed[d.DagId] = true
+		if _, ok := snapshot.Paused[d.DagId]; !ok {
+			appeared = append(appeared, d.DagId)
+		}
+	}
+	vanished := []string{}
+	for dagId := range snapshot.Paused {
+		if !listed[dagId] {
+			vanished = append(vanished, dagId)
+		}
+	}
+	sort.Strings(appeared)
+	sort.Strings(vanished)
+	return appeared, vanished
+}
+
+/*
+EnterMaintenance records every DAG's paused state to a snapshot file, then pauses every DAG
+
+If the snapshot file already exists, e.g. the process died mid way, it is reused rather than overwritten, so the state from before the first call is kept.
+DAGs that appeared since are added to it with their current paused state.
+
+@param path string - snapshot file, pass the same path to ExitMaintenance
+
+@return MaintenanceResult - the pause outcome per DAG and the DAGs that appeared or vanished since the snapshot was taken
+*/
+func (cli *CLIENT) EnterMaintenance(path string, opts BulkOptions) (MaintenanceResult, error) {
+	result := MaintenanceResult{Snapshot: path, Appeared: []string{}, Vanished: []string{}}
+	dags, err := cli.ListDags()
+	if err != nil {
+		return result, err
+	}
+	snapshot, err := LoadMaintenanceSnapshot(path)
+	if errors.Is(err, os.ErrNotExist) {
+		snapshot = MaintenanceSnapshot{Environment: *cli.Name, CreatedAt: time.Now().UTC(), Paused: map[string]bool{}}
+	} else if err != nil {
+		return result, err
+	} else {
+		result.Resumed = true
+		result.Appeared, result.Vanished = diffSnapshot(snapshot, dags)
+	}
+	current := map[string]bool{}
+	targets := map[string]bool{}
+	dagIds := make([]string, len(dags))
+	for i, d := range dags {
+		dagIds[i] = d.DagId
+		current[d.DagId] = d.Paused
+		targets[d.DagId] = true
+		if _, ok := snapshot.Paused[d.DagId]; !ok {
+			snapshot.Paused[d.DagId] = d.Paused
+		}
+	}
+	// the snapshot must be on disk before anything is paused
+	if !opts.DryRun {
+		if err := SaveMaintenanceSnapshot(path, snapshot); err != nil {
+			return result, err
+		}
+	}
+	result.Bulk = cli.applyPausedStates(current, targets, dagIds, opts)
+	if result.Bulk.Failed > 0 {
+		return result, fmt.Errorf("%d DAGs failed to pause", result.Bulk.Failed)
+	}
+	return result, nil
+}
+
+/*
+ExitMaintenance restores the paused state recorded by EnterMaintenance
+
+DAGs that appeared during maintenance are left alone and reported.
+The snapshot file is removed once every DAG is restored, and kept otherwise so the call can be retried.
+
+@param path string - snapshot file written by EnterMaintenance
+
+@return MaintenanceResult - the restore outcome per DAG and the DAGs that appeared or vanished since the snapshot was taken
+*/
+func (cli *CLIENT) ExitMaintenance(path string, opts BulkOptions) (MaintenanceResult, error) {
+	result := MaintenanceResult{Snapshot: path, Appeared: []string{}, Vanished: []string{}}
+	snapshot, err := LoadMaintenanceSnapshot(path)
+	if err != nil {
+		return result, err
+	}
+	dags, err := cli.ListDags()
+	if err != nil {
+		return result, err
+	}
+	result.Appeared, result.Vanished = diffSnapshot(snapshot, dags)
+	current := map[string]bool{}
+	dagIds := []string{}
+	for _, d := range dags {
+		if _, ok := snapshot.Paused[d.DagId]; ok {
+			dagIds = append(dagIds, d.DagId)
+			current[d.DagId] = d.Paused
+		}
+	}
+	result.Bulk = cli.applyPausedStates(current, snapshot.Paused, dagIds, opts)
+	if opts.DryRun {
+		return result, nil
+	}
+	if result.Bulk.Failed > 0 {
+		return result, fmt.Errorf("%d DAGs failed to restore, snapshot kept at %s", result.Bulk.Failed, path)
+	}
+	return result, os.Remove(path)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -20,7 +21,18 @@ type CLIENT struct {
 	host            *string
 	tokenOutput     *mwaa.CreateCliTokenOutput
 	tokenExpiration time.Time
-	//version *string
+	// guards tokenOutput and tokenExpiration, commands may be posted concurrently
+	tokenMu sync.Mutex
+	// guards version
+	mu      sync.Mutex
+	version *Version
+	// guards confSchemas, created on the first RegisterConfSchema
+	schemaMu    sync.Mutex
+	confSchemas map[string]*JSONSchema
+	// receives GetEnvironment and UpdateEnvironment calls instead of svc when set
+	envAPI environmentAPI
+	// answers validated commands instead of the MWAA web server when set, e.g. in tests
+	post func(cmd string) (MWAAData, error)
 }
 
 type MWAAData struct {
@@ -76,21 +88,31 @@ func PostMWAACommand(cli *CLIENT, cmd string) (MWAAData, error) {
 	// https://airflow.apache.org/docs/apache-airflow/2.2.2/cli-and-env-variables-ref.html
 	// https://airflow.apache.org/docs/apache-airflow/2.2.2/cli-and-env-variables-ref.html#command-line-interface
 	// now := time.Now()
+	// fail fast on commands MWAA does not permit, or commands and flags the environment's airflow version does not have
+	if err := cli.validateCommand(cmd); err != nil {
+		return MWAAData{}, err
+	}
+	if cli.post != nil {
+		return cli.post(cmd)
+	}
+	cli.tokenMu.Lock()
 	if time.Now().After(cli.tokenExpiration) {
 		refreshToken(cli)
 	}
+	token := cli.tokenOutput
+	cli.tokenMu.Unlock()
 	client := http.Client{
 		Timeout: time.Second * 60,
 	}
 	body := strings.NewReader(cmd)
 
-	req, err := http.NewRequest(http.MethodPost, `https://`+*cli.tokenOutput.WebServerHostname+`/aws_mwaa/cli`, body)
+	req, err := http.NewRequest(http.MethodPost, `https://`+*token.WebServerHostname+`/aws_mwaa/cli`, body)
 	if err != nil {
 		return MWAAData{}, err
 	}
 	req.Header = http.Header{
 		"Content-Type":  {"text/plain"},
-		"Authorization": {"Bearer " + *cli.tokenOutput.CliToken},
+		"Authorization": {"Bearer " + *token.CliToken},
 	}
 	resp, err := client.Do(req)
 	if resp != nil {
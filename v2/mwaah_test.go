@@ -3,17 +3,25 @@
 package mwaah
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/apache/airflow-client-go/airflow"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/awstesting/mock"
 	"github.com/aws/aws-sdk-go/service/mwaa"
 )
@@ -292,3 +300,1828 @@ func TestCopyrightHeadersExist(t *testing.T) {
 		panic("\n found files without a valid copyright header:\n" + strings.Join(*missingPrefix, "\n"))
 	}
 }
+
+// stands in for a backend that only implements GetVersion
+type versionBackend struct {
+	Airflow
+	caps    []Capability
+	version string
+}
+
+func (b versionBackend) Capabilities() []Capability {
+	return b.caps
+}
+
+func (b versionBackend) GetVersion() (string, error) {
+	return b.version, nil
+}
+
+func TestRouter(t *testing.T) {
+	rolesOnly := versionBackend{caps: []Capability{CapabilityRoles}, version: "roles"}
+	versioned := versionBackend{caps: []Capability{CapabilityVersion}, version: "2.2.2"}
+	tests := []struct {
+		name     string
+		backends []Backend
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "RoutesByCapability",
+			backends: []Backend{rolesOnly, versioned},
+			want:     "2.2.2",
+		},
+		{
+			name:     "NoBackend",
+			backends: []Backend{rolesOnly},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRouter(tt.backends...).GetVersion()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetVersion() error = %+v, wantErr %+v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrNoBackend) {
+				t.Errorf("GetVersion() error = %+v, want ErrNoBackend", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetVersion() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    Version
+		wantErr bool
+	}{
+		{name: "Plain", args: "2.2.2", want: ver(2, 2, 2)},
+		{name: "LogPrefixed", args: stderr + "2.5.1", want: ver(2, 5, 1)},
+		{name: "MajorMinor", args: "v2.4", want: ver(2, 4, 0)},
+		{name: "Garbage", args: "not a version", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVersion(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseVersion() error = %+v, wantErr %+v", err, tt.wantErr)
+			}
+			if got.Compare(tt.want) != 0 {
+				t.Errorf("ParseVersion() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSupport(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		version Version
+		wantErr bool
+	}{
+		{name: "Supported", cmd: `dags list --output json`, version: ver(2, 2, 2)},
+		{name: "UnsupportedCommand", cmd: `dags list-import-errors --output json`, version: ver(2, 0, 2), wantErr: true},
+		{name: "UnsupportedFlag", cmd: `dags list --columns 'dag_id' --output json`, version: ver(2, 5, 1), wantErr: true},
+		{name: "QuotedFlagLikeValue", cmd: `variables set '--columns' 'x'`, version: ver(2, 0, 2)},
+		{name: "UnknownCommand", cmd: `cheat-sheet`, version: ver(2, 0, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parseCommand(tt.cmd)
+			err := CheckSupport(tt.version, p.Command, p.Flags...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckSupport(%+v) error = %+v, wantErr %+v", p, err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrUnsupportedByVersion) {
+				t.Errorf("CheckSupport() error = %+v, want ErrUnsupportedByVersion", err)
+			}
+		})
+	}
+}
+
+func TestCheckAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		version Version
+		wantErr bool
+	}{
+		{name: "Allowed", command: "dags list", version: ver(2, 2, 2)},
+		{name: "TooOld", command: "dags list", version: ver(2, 0, 2), wantErr: true},
+		{name: "NeverAllowed", command: "db reset", version: ver(2, 5, 1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAllowed(tt.version, tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckAllowed() error = %+v, wantErr %+v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrCommandNotAllowed) {
+				t.Errorf("CheckAllowed() error = %+v, want ErrCommandNotAllowed", err)
+			}
+		})
+	}
+	tree := AllowedCommandTree(ver(2, 0, 2))
+	if _, ok := tree["version"]; !ok {
+		t.Errorf("AllowedCommandTree() = %+v, missing version", tree)
+	}
+	for _, sub := range tree["dags"] {
+		if sub == "list" {
+			t.Errorf("AllowedCommandTree() = %+v, dags list is not allowed on 2.0.2", tree)
+		}
+	}
+}
+
+func TestDiffConfigOverrides(t *testing.T) {
+	current := ConfigOverrides{"core.parallelism": "32", "scheduler.min_file_process_interval": "30"}
+	desired := ConfigOverrides{"core.parallelism": "64", "secrets.backend": "airflow.providers.amazon.aws.secrets.secrets_manager.SecretsManagerBackend"}
+	want := ConfigDiff{
+		Added:   map[string]string{"secrets.backend": desired["secrets.backend"]},
+		Changed: map[string]ConfigChange{"core.parallelism": {From: "32", To: "64"}},
+		Removed: map[string]string{"scheduler.min_file_process_interval": "30"},
+	}
+	if got := DiffConfigOverrides(current, desired); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffConfigOverrides() = %+v, want %+v", got, want)
+	}
+	if got := DiffConfigOverrides(current, current); !got.IsEmpty() {
+		t.Errorf("DiffConfigOverrides() = %+v, want empty", got)
+	}
+}
+
+func TestValidateConfigOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides ConfigOverrides
+		wantErr   bool
+	}{
+		{name: "Valid", overrides: ConfigOverrides{"core.parallelism": "64"}},
+		{name: "Reserved", overrides: ConfigOverrides{"core.executor": "LocalExecutor"}, wantErr: true},
+		{name: "Malformed", overrides: ConfigOverrides{"parallelism": "64"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateConfigOverrides(tt.overrides); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigOverrides() error = %+v, wantErr %+v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBucketFromArn(t *testing.T) {
+	tests := []struct {
+		name    string
+		arn     string
+		want    string
+		wantErr bool
+	}{
+		{name: "Bucket", arn: "arn:aws:s3:::my-airflow-bucket", want: "my-airflow-bucket"},
+		{name: "NotS3", arn: "arn:aws:iam::123456789:role/my-execution-role", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BucketFromArn(tt.arn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BucketFromArn() error = %+v, wantErr %+v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BucketFromArn() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryObjectStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryObjectStore()
+	first, _ := store.PutObject(ctx, "bucket", "requirements.txt", []byte("boto3==1.26.0\n"))
+	second, _ := store.PutObject(ctx, "bucket", "requirements.txt", []byte("boto3==1.26.1\n"))
+	if first == second {
+		t.Errorf("PutObject() returned the same version twice: %s", first)
+	}
+	body, err := store.GetObjectVersion("bucket", "requirements.txt", first)
+	if err != nil || string(body) != "boto3==1.26.0\n" {
+		t.Errorf("GetObjectVersion() = %s, %+v", body, err)
+	}
+	store.DeleteObject(ctx, "bucket", "requirements.txt")
+	objects, _ := store.ListObjects(ctx, "bucket", "")
+	if len(objects) != 0 {
+		t.Errorf("ListObjects() = %+v, want none after delete", objects)
+	}
+}
+
+func TestReadDagsDir(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub", "__pycache__"), 0o755)
+	os.WriteFile(filepath.Join(dir, "example_dag.py"), []byte("from airflow import DAG\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sub", "helpers.py"), []byte("def helper(): pass\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sub", "__pycache__", "helpers.cpython-37.pyc"), []byte{0}, 0o644)
+	os.WriteFile(filepath.Join(dir, ".airflowignore"), []byte("sub/\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".DS_Store"), []byte{0}, 0o644)
+	files, err := readDagsDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".airflowignore", "example_dag.py", "sub/helpers.py"}
+	var got []string
+	for name := range files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readDagsDir() = %+v, want %+v", got, want)
+	}
+	if !mightContainDag(files["example_dag.py"]) || mightContainDag(files["sub/helpers.py"]) {
+		t.Errorf("mightContainDag() did not match airflow's safe mode heuristic")
+	}
+}
+
+func TestCorrelateImportErrors(t *testing.T) {
+	data := MWAAData{Stdout: []byte(`[{"filepath": "/usr/local/airflow/dags/broken_dag.py", "error": "Traceback (most recent call last):\nModuleNotFoundError: No module named 'pandas'\n"}, {"filepath": "/usr/local/airflow/dags/gone.py", "error": "SyntaxError: invalid syntax"}]`)}
+	importErrors, err := UnmarshalImportErrors(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := DagReport{
+		{File: "/example_dag.py", Dags: []string{"example_dag"}},
+		{File: "/broken_dag.py"},
+	}
+	want := []FileParseStatus{
+		{File: "/example_dag.py", Dags: []string{"example_dag"}},
+		{File: "/broken_dag.py", ImportError: importErrors[0].Error},
+		{File: "/usr/local/airflow/dags/gone.py", ImportError: importErrors[1].Error},
+	}
+	got := CorrelateImportErrors(report, importErrors)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CorrelateImportErrors() = %+v, want %+v", got, want)
+	}
+	if !got[0].Parsed() || got[1].Parsed() {
+		t.Errorf("Parsed() = %v, %v, want true, false", got[0].Parsed(), got[1].Parsed())
+	}
+	// files sharing a name in different folders
+	etl := ImportError{File: "/usr/local/airflow/dags/b/etl.py", Error: "SyntaxError: invalid syntax"}
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"b/etl.py", true},
+		{"/b/etl.py", true},
+		{"a/etl.py", false},
+		{"etl.py", false},
+		{"ab/etl.py", false},
+	}
+	for _, tt := range tests {
+		if got := etl.IsFor(tt.file); got != tt.want {
+			t.Errorf("IsFor(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+	report = DagReport{{File: "/a/etl.py", Dags: []string{"a_etl"}}, {File: "/b/etl.py"}}
+	statuses := CorrelateImportErrors(report, []ImportError{etl})
+	if !statuses[0].Parsed() || statuses[1].Parsed() || len(statuses) != 2 {
+		t.Errorf("CorrelateImportErrors() = %+v, want the error on b/etl.py only", statuses)
+	}
+}
+
+func TestPendingDagFiles(t *testing.T) {
+	before := DagReport{{File: "/modified.py", TaskNum: 2, Dags: []string{"modified"}}}
+	after := DagReport{
+		{File: "/new.py", TaskNum: 1, Dags: []string{"new"}},
+		{File: "/modified.py", TaskNum: 3, Dags: []string{"modified"}},
+		{File: "/broken.py"},
+	}
+	importErrors := []ImportError{{File: "/usr/local/airflow/dags/broken.py", Error: "SyntaxError: invalid syntax"}}
+	files := []string{"new.py", "modified.py", "broken.py"}
+	states := func(report DagReport, importErrors []ImportError) map[string]dagFileState {
+		got := map[string]dagFileState{}
+		for _, name := range files {
+			got[name] = dagFileStateOf(report, importErrors, name)
+		}
+		return got
+	}
+	never := func([]string) bool { return false }
+	tests := []struct {
+		name              string
+		baseline          map[string]dagFileState
+		current           map[string]dagFileState
+		parsedAfterUpload func([]string) bool
+		wantPending       []string
+		wantUnparsed      []string
+	}{
+		{"nothing parsed yet", states(before, nil), states(before, nil), never, []string{"new.py", "modified.py", "broken.py"}, []string{}},
+		{"all parsed", states(before, nil), states(after, importErrors), never, []string{}, []string{"broken.py"}},
+		// the modified file is listed from its old version until its entry changes
+		{"modified file unchanged", states(after, nil), states(after, importErrors), never, []string{"new.py", "modified.py"}, []string{"broken.py"}},
+		{"modified file parsed after upload", states(after, nil), states(after, importErrors), func([]string) bool { return true }, []string{}, []string{"broken.py"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending, unparsed := pendingDagFiles(files, tt.baseline, tt.current, tt.parsedAfterUpload)
+			if !reflect.DeepEqual(pending, tt.wantPending) || !reflect.DeepEqual(unparsed, tt.wantUnparsed) {
+				t.Errorf("pendingDagFiles() = %v, %v, want %v, %v", pending, unparsed, tt.wantPending, tt.wantUnparsed)
+			}
+		})
+	}
+}
+
+func TestCheckDagDriftErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		version Version
+		respond func(cmd string) (MWAAData, error)
+		wantErr error
+	}{
+		{"dags list not allowed", Version{Major: 2, Minor: 0, Patch: 2}, nil, ErrCommandNotAllowed},
+		{"dags list fails", Version{Major: 2, Minor: 8, Patch: 1}, func(cmd string) (MWAAData, error) {
+			return MWAAData{}, errors.New("Status: 503 Service Unavailable")
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := stubClient(tt.respond)
+			cli.SetAirflowVersion(tt.version)
+			_, err := cli.CheckDagDrift([]ExpectedDag{{DagId: "example_dag"}})
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("CheckDagDrift() error = %v, want an error", err)
+			}
+		})
+	}
+}
+
+func TestCompareDags(t *testing.T) {
+	var dags Dags
+	err := json.Unmarshal([]byte(`[
+		{"dag_id": "example_dag", "filepath": "/usr/local/airflow/dags/team/example_dag.py", "owner": "airflow, data-eng", "paused": "False"},
+		{"dag_id": "moved_dag", "filepath": "/usr/local/airflow/dags/other.py", "owner": "airflow", "paused": "True"},
+		{"dag_id": "stray_dag", "filepath": "/usr/local/airflow/dags/stray.py", "owner": "airflow", "paused": "False"}
+	]`), &dags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := DagReport{
+		{File: "/team/example_dag.py", Dags: []string{"example_dag"}},
+		{File: "/other.py", Dags: []string{"moved_dag"}},
+		{File: "/stray.py", Dags: []string{"stray_dag"}},
+	}
+	expected := []ExpectedDag{
+		{DagId: "example_dag", FilePath: "team/example_dag.py", Owners: []string{"data-eng", "airflow"}, TaskCount: 3},
+		{DagId: "moved_dag", FilePath: "moved_dag.py", Owners: []string{"data-eng"}},
+		{DagId: "missing_dag"},
+	}
+	want := DriftReport{
+		Missing:          []string{"missing_dag"},
+		Unexpected:       []string{"stray_dag"},
+		Moved:            []DagMove{{DagId: "moved_dag", Expected: "moved_dag.py", Actual: "/other.py"}},
+		TaskCountChanged: []TaskCountChange{{DagId: "example_dag", Expected: 3, Actual: 4}},
+		OwnersChanged:    []OwnersChange{{DagId: "moved_dag", Expected: []string{"data-eng"}, Actual: []string{"airflow"}}},
+	}
+	got := CompareDags(expected, dags, report, map[string]int{"example_dag": 4})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CompareDags() = %+v, want %+v", got, want)
+	}
+	if !got.HasDrift() {
+		t.Errorf("HasDrift() = false, want true")
+	}
+}
+
+func TestUnmarshalDagsReport(t *testing.T) {
+	data := MWAAData{Stdout: []byte(`[
+		{"file": "/example_dag.py", "duration": "0:00:00.012345", "dag_num": 1, "task_num": 4, "dags": ["example_dag"]},
+		{"file": "/slow_dag.py", "duration": "0:00:02.5", "dag_num": "2", "task_num": "10", "dags": "['a_dag', 'b_dag']"}
+	]`)}
+	want := DagReport{
+		{File: "/example_dag.py", Duration: 12345 * time.Microsecond, DagNum: 1, TaskNum: 4, Dags: []string{"example_dag"}},
+		{File: "/slow_dag.py", Duration: 2500 * time.Millisecond, DagNum: 2, TaskNum: 10, Dags: []string{"a_dag", "b_dag"}},
+	}
+	got, err := UnmarshalDagsReport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalDagsReport() = %+v, want %+v", got, want)
+	}
+	if slowest := got.Slowest(1); slowest[0].File != "/slow_dag.py" {
+		t.Errorf("Slowest() = %+v", slowest)
+	}
+	if over := got.OverThreshold(time.Second); len(over) != 1 {
+		t.Errorf("OverThreshold() = %+v", over)
+	}
+	m, _ := json.Marshal(got)
+	var roundTrip DagReport
+	if err := json.Unmarshal(m, &roundTrip); err != nil || !reflect.DeepEqual(roundTrip, want) {
+		t.Errorf("round trip = %+v, %+v", roundTrip, err)
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	before := DagReport{
+		{File: "/a.py", Duration: 100 * time.Millisecond},
+		{File: "/b.py", Duration: time.Second},
+		{File: "/c.py", Duration: time.Second},
+	}
+	after := DagReport{
+		{File: "/a.py", Duration: 900 * time.Millisecond},
+		{File: "/b.py", Duration: 1200 * time.Millisecond},
+		{File: "/c.py", Duration: 500 * time.Millisecond},
+		{File: "/d.py", Duration: 2 * time.Second},
+	}
+	want := []ParseRegression{
+		{File: "/d.py", After: 2 * time.Second, Delta: 2 * time.Second, New: true},
+		{File: "/a.py", Before: 100 * time.Millisecond, After: 900 * time.Millisecond, Delta: 800 * time.Millisecond, Ratio: 9},
+	}
+	got := CompareReports(before, after, RegressionOptions{MinDelta: 500 * time.Millisecond, MinRatio: 1.5})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CompareReports() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFilterDags(t *testing.T) {
+	data := MWAAData{Stdout: []byte(`[
+		{"dag_id": "sales_daily", "filepath": "/usr/local/airflow/dags/sales/daily.py", "owner": "airflow, sales", "paused": "False"},
+		{"dag_id": "sales_hourly", "fileloc": "/usr/local/airflow/dags/sales/hourly.py", "owners": ["sales"], "is_paused": true, "tags": [{"name": "critical"}], "schedule_interval": {"__type": "CronExpression", "value": "0 * * * *"}, "is_active": true},
+		{"dag_id": "marketing", "filepath": "/usr/local/airflow/dags/marketing.py", "owner": "marketing", "paused": false}
+	]`)}
+	dags, err := UnmarshalListDags(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := true
+	wantHourly := DAG{
+		DagId:    "sales_hourly",
+		FilePath: "/usr/local/airflow/dags/sales/hourly.py",
+		File:     "sales/hourly.py",
+		Owners:   []string{"sales"},
+		Paused:   true,
+		Tags:     []string{"critical"},
+		Schedule: "0 * * * *",
+		Active:   &active,
+	}
+	if !reflect.DeepEqual(dags[1], wantHourly) {
+		t.Errorf("UnmarshalListDags() = %+v, want %+v", dags[1], wantHourly)
+	}
+	paused := false
+	tests := []struct {
+		name  string
+		query DagQuery
+		want  []string
+	}{
+		{name: "IdRegex", query: DagQuery{IdRegex: "^sales_"}, want: []string{"sales_daily", "sales_hourly"}},
+		{name: "Owner", query: DagQuery{Owner: "sales"}, want: []string{"sales_daily", "sales_hourly"}},
+		{name: "Tag", query: DagQuery{Tag: "critical"}, want: []string{"sales_hourly"}},
+		{name: "Paused", query: DagQuery{Paused: &paused}, want: []string{"sales_daily", "marketing"}},
+		{name: "FileGlob", query: DagQuery{FileGlob: "sales/*.py", Owner: "airflow"}, want: []string{"sales_daily"}},
+		{name: "FilePrefix", query: DagQuery{FilePrefix: "/sales/"}, want: []string{"sales_daily", "sales_hourly"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterDags(dags, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, d := range got {
+				ids = append(ids, d.DagId)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("FilterDags() = %+v, want %+v", ids, tt.want)
+			}
+		})
+	}
+	SortDags(dags, SortByDagId, false)
+	if dags[0].DagId != "marketing" {
+		t.Errorf("SortDags() = %+v", dags)
+	}
+	var buf strings.Builder
+	if err := WriteDagsCSV(&buf, dags[:1]); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "dag_id,file,owners,paused,tags,schedule,active\nmarketing,marketing.py,marketing,false,,,\n"
+	if buf.String() != wantCSV {
+		t.Errorf("WriteDagsCSV() = %q, want %q", buf.String(), wantCSV)
+	}
+}
+
+func TestForEachLimit(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight, calls := 0, 0, 0
+	forEachLimit(20, 3, func(i int) {
+		mu.Lock()
+		inFlight++
+		calls++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	})
+	if calls != 20 || maxInFlight > 3 {
+		t.Errorf("forEachLimit() made %d calls with up to %d in flight, want 20 with at most 3", calls, maxInFlight)
+	}
+}
+
+func TestApplyPausedStatesDryRun(t *testing.T) {
+	cli := &CLIENT{}
+	current := map[string]bool{"a": true, "b": false}
+	targets := map[string]bool{"a": true, "b": true}
+	got := cli.applyPausedStates(current, targets, []string{"a", "b"}, BulkOptions{DryRun: true})
+	want := BulkResult{
+		Results: []DagActionResult{
+			{DagId: "a", Action: ActionSkip, DryRun: true},
+			{DagId: "b", Action: ActionPause, DryRun: true},
+		},
+		Succeeded: 1,
+		Skipped:   1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applyPausedStates() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPauseDagsEmptySelector(t *testing.T) {
+	fake := &fakeAirflow{}
+	cli := stubClient(fake.respond)
+	if _, err := cli.PauseDags(DagQuery{}, BulkOptions{}); !errors.Is(err, ErrEmptySelector) {
+		t.Errorf("PauseDags() of an empty selector = %v, want ErrEmptySelector", err)
+	}
+	if _, err := cli.UnpauseDags(DagQuery{}, BulkOptions{DryRun: true}); !errors.Is(err, ErrEmptySelector) {
+		t.Errorf("UnpauseDags() of an empty selector = %v, want ErrEmptySelector", err)
+	}
+	if len(fake.posted) != 0 {
+		t.Errorf("posted %v, want nothing", fake.posted)
+	}
+	// with All the DAGs are listed
+	if _, err := cli.PauseDags(DagQuery{}, BulkOptions{All: true, DryRun: true}); errors.Is(err, ErrEmptySelector) || len(fake.commands("dags list")) == 0 {
+		t.Errorf("PauseDags() with All = %v, want the DAGs listed", err)
+	}
+}
+
+func TestMaintenanceSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.json")
+	if _, err := LoadMaintenanceSnapshot(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadMaintenanceSnapshot() error = %v, want os.ErrNotExist", err)
+	}
+	snapshot := MaintenanceSnapshot{
+		Environment: "env",
+		CreatedAt:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		Paused:      map[string]bool{"kept_paused": true, "running": false, "deleted": false},
+	}
+	if err := SaveMaintenanceSnapshot(path, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadMaintenanceSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, snapshot) {
+		t.Errorf("LoadMaintenanceSnapshot() = %+v, want %+v", got, snapshot)
+	}
+	dags := []DAG{{DagId: "kept_paused", Paused: true}, {DagId: "running", Paused: true}, {DagId: "new"}}
+	appeared, vanished := diffSnapshot(got, dags)
+	if !reflect.DeepEqual(appeared, []string{"new"}) || !reflect.DeepEqual(vanished, []string{"deleted"}) {
+		t.Errorf("diffSnapshot() = %v, %v, want [new], [deleted]", appeared, vanished)
+	}
+	// restoring only unpauses what was running before
+	cli := &CLIENT{}
+	current := map[string]bool{"kept_paused": true, "running": true}
+	restore := cli.applyPausedStates(current, got.Paused, []string{"kept_paused", "running"}, BulkOptions{DryRun: true})
+	want := []DagActionResult{
+		{DagId: "kept_paused", Action: ActionSkip, DryRun: true},
+		{DagId: "running", Action: ActionUnpause, DryRun: true},
+	}
+	if !reflect.DeepEqual(restore.Results, want) {
+		t.Errorf("applyPausedStates() = %+v, want %+v", restore.Results, want)
+	}
+}
+
+const testDagDot = `digraph example_task_group {
+	graph [label=example_task_group labelloc=t rankdir=LR]
+	end [color="#000000" fillcolor="#e8f7e4" label=end shape=rectangle style="filled,rounded"]
+	subgraph cluster_section_1 {
+		color="#000000" fillcolor="#6495ed7f" label=section_1 shape=box style=filled
+		"section_1.upstream_join_id" [color="#000000" fillcolor=CornflowerBlue height=0.2 label="" shape=circle style="filled,dashed" width=0.2]
+		"section_1.downstream_join_id" [color="#000000" fillcolor=CornflowerBlue height=0.2 label="" shape=circle style="filled,dashed" width=0.2]
+		"section_1.task_1" [color="#000000" fillcolor=green label=task_1 shape=rectangle style="filled,rounded"]
+		"section_1.task_2" [color="#000000" fillcolor="#e8f7e4" label=task_2 shape=rectangle style="filled,rounded"]
+	}
+	start [color="#000000" fillcolor="#e8f7e4" label=start shape=rectangle style="filled,rounded"]
+	"section_1.downstream_join_id" -> end
+	"section_1.task_1" -> "section_1.task_2"
+	"section_1.task_2" -> "section_1.downstream_join_id"
+	"section_1.upstream_join_id" -> "section_1.task_1"
+	start -> "section_1.upstream_join_id"
+}`
+
+func TestParseDagGraph(t *testing.T) {
+	g, err := ParseDagGraph(testDagDot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.DagId != "example_task_group" {
+		t.Errorf("DagId = %s, want example_task_group", g.DagId)
+	}
+	wantIds := []string{"end", "section_1.task_1", "section_1.task_2", "start"}
+	if !reflect.DeepEqual(g.TaskIds(), wantIds) {
+		t.Errorf("TaskIds() = %v, want %v", g.TaskIds(), wantIds)
+	}
+	task1, _ := g.Node("section_1.task_1")
+	wantTask1 := DagGraphNode{TaskId: "section_1.task_1", Label: "task_1", Color: "#000000", FillColor: "green", Group: "section_1"}
+	if task1 != wantTask1 {
+		t.Errorf("Node() = %+v, want %+v", task1, wantTask1)
+	}
+	g.StatesFromColors()
+	task1, _ = g.Node("section_1.task_1")
+	task2, _ := g.Node("section_1.task_2")
+	if task1.State != "success" || task2.State != "" {
+		t.Errorf("StatesFromColors() = %s, %s, want success and no state", task1.State, task2.State)
+	}
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{name: "TopologicalOrder", got: order, want: []string{"start", "section_1.task_1", "section_1.task_2", "end"}},
+		{name: "Roots", got: g.Roots(), want: []string{"start"}},
+		{name: "Leaves", got: g.Leaves(), want: []string{"end"}},
+		{name: "Upstream", got: g.Upstream("section_1.task_2"), want: []string{"section_1.task_1", "start"}},
+		{name: "Downstream", got: g.Downstream("section_1.task_1"), want: []string{"end", "section_1.task_2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s() = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+	cyclic := &DagGraph{
+		Nodes: []DagGraphNode{{TaskId: "a"}, {TaskId: "b"}, {TaskId: "c"}},
+		Edges: []DagEdge{{From: "a", To: "b"}, {From: "b", To: "c"}, {From: "c", To: "b"}},
+	}
+	if _, err := cyclic.TopologicalOrder(); !errors.Is(err, ErrDagCycle) || !strings.HasSuffix(err.Error(), "b -> c -> b") {
+		t.Errorf("TopologicalOrder() error = %v, want ErrDagCycle b -> c -> b", err)
+	}
+	shown, err := UnmarshalDagDiGraph(MWAAData{Stdout: []byte(testDagDot + "\n"), StdoutStr: testDagDot})
+	if err != nil || shown != testDagDot {
+		t.Errorf("UnmarshalDagDiGraph() = %q, %v, want the digraph", shown, err)
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	g, err := ParseDagGraph(testDagDot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task1, task2 := "section_1.task_1", "section_1.task_2"
+	g.ApplyStates([]TaskStatesDetailed{{dagFields: dagFields{State: "success"}, TaskId: &task1}, {dagFields: dagFields{State: "failed"}, TaskId: &task2}})
+	var b strings.Builder
+	if err := WriteMermaid(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	want := `flowchart LR
+    subgraph group_section_1 ["section_1"]
+        task_section_1_task_1["task_1"]
+        task_section_1_task_2["task_2"]
+    end
+    task_end["end"]
+    task_start["start"]
+    task_section_1_task_1 --> task_section_1_task_2
+    task_section_1_task_2 --> task_end
+    task_start --> task_section_1_task_1
+    classDef failed fill:red
+    class task_section_1_task_2 failed
+    classDef success fill:green
+    class task_section_1_task_1 success
+`
+	if b.String() != want {
+		t.Errorf("WriteMermaid() = %s, want %s", b.String(), want)
+	}
+	b.Reset()
+	if err := WritePlantUML(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`(*) --> "start" as task_start`,
+		`partition "section_1" {`,
+		`    task_section_1_task_1 --> "task_2" as task_section_1_task_2 <<failed>>`,
+		`task_end --> (*)`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("WritePlantUML() = %s, want a line %s", b.String(), line)
+		}
+	}
+	adjacency := g.Adjacency()
+	want2 := AdjacencyEntry{Group: "section_1", State: "failed", Upstream: []string{"section_1.task_1"}, Downstream: []string{"end"}}
+	if !reflect.DeepEqual(adjacency.Tasks[task2], want2) {
+		t.Errorf("Adjacency() = %+v, want %+v", adjacency.Tasks[task2], want2)
+	}
+}
+
+func TestParseTaskTree(t *testing.T) {
+	tree, err := ParseTaskTree(strings.Join([]string{
+		"[2022-12-01 00:00:00,000] {dagbag.py:500} INFO - Filling up the DagBag from /usr/local/airflow/dags",
+		"<Task(BashOperator): extract>",
+		"    <Mapped(PythonOperator): transform>",
+		"        <Task(EmptyOperator): load>",
+		"    <Task(BashOperator): validate>",
+		"        <Task(EmptyOperator): load>",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walked []string
+	tree.Walk(func(n *TaskTreeNode) error {
+		walked = append(walked, fmt.Sprintf("%d:%s:%s:%t:%t", n.Depth, n.TaskId, n.Operator, n.Mapped, n.Repeated))
+		return nil
+	})
+	wantWalked := []string{
+		"0:extract:BashOperator:false:false",
+		"1:transform:PythonOperator:true:false",
+		"2:load:EmptyOperator:false:false",
+		"1:validate:BashOperator:false:false",
+		"2:load:EmptyOperator:false:true",
+	}
+	if !reflect.DeepEqual(walked, wantWalked) {
+		t.Errorf("Walk() = %v, want %v", walked, wantWalked)
+	}
+	g := tree.ToGraph()
+	wantEdges := []DagEdge{{"extract", "transform"}, {"transform", "load"}, {"extract", "validate"}, {"validate", "load"}}
+	if !reflect.DeepEqual(g.TaskIds(), []string{"extract", "transform", "load", "validate"}) || !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("ToGraph() = %+v, want 4 tasks and edges %v", g, wantEdges)
+	}
+	tasks, err := UnmarshalDagTasks(MWAAData{StdoutStr: "extract\nload\n\ntransform"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 || *tasks[2].TaskId != "transform" || tasks[2].Operator.IsSet() {
+		t.Errorf("UnmarshalDagTasks() = %+v, want 3 tasks without operators", tasks)
+	}
+}
+
+func TestDagRunWaitBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		opts DagRunWaitOptions
+		from time.Duration
+		want time.Duration
+	}{
+		{name: "default backoff", opts: DagRunWaitOptions{}, from: 10 * time.Second, want: 15 * time.Second},
+		{name: "capped", opts: DagRunWaitOptions{Backoff: 2, MaxInterval: 30 * time.Second}, from: 20 * time.Second, want: 30 * time.Second},
+		{name: "fixed", opts: DagRunWaitOptions{Backoff: 1}, from: 5 * time.Second, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.next(tt.from); got != tt.want {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	extract, load, report := "extract", "load", "report"
+	tasks := []TaskStatesDetailed{
+		{dagFields: dagFields{State: "success"}, TaskId: &extract},
+		{dagFields: dagFields{State: "failed"}, TaskId: &report},
+		{dagFields: dagFields{State: "failed"}, TaskId: &load},
+		{dagFields: dagFields{State: "upstream_failed"}},
+	}
+	if got := failedTasks(tasks); !reflect.DeepEqual(got, []string{"load", "report"}) {
+		t.Errorf("failedTasks() = %v, want [load report]", got)
+	}
+}
+
+// returns a client on airflow 2.8.1 whose commands are answered by respond
+func stubClient(respond func(cmd string) (MWAAData, error)) *CLIENT {
+	name := "testInstanceName"
+	cli := &CLIENT{Name: &name, post: respond}
+	cli.SetAirflowVersion(Version{Major: 2, Minor: 8, Patch: 1})
+	return cli
+}
+
+var listRunsFlagRegexp = regexp.MustCompile(`--(dag-id|start-date|end-date) '([^']*)'`)
+
+// answers `dags list-runs` from runs the way airflow does, the dates filter logical dates from midnight
+func listRunsResponse(cmd string, runs []airflow.DAGRun) MWAAData {
+	flags := map[string]string{}
+	for _, m := range listRunsFlagRegexp.FindAllStringSubmatch(cmd, -1) {
+		flags[m[1]] = m[2]
+	}
+	listed := []map[string]string{}
+	for _, run := range runs {
+		date := runLogicalDate(run)
+		if run.GetDagId() != flags["dag-id"] {
+			continue
+		}
+		if start, err := time.Parse(listDagRunsDateLayout, flags["start-date"]); err == nil && date.Before(start) {
+			continue
+		}
+		if end, err := time.Parse(time.RFC3339Nano, flags["end-date"]); err == nil && date.After(end) {
+			continue
+		}
+		listed = append(listed, map[string]string{
+			"dag_id":         run.GetDagId(),
+			"run_id":         run.GetDagRunId(),
+			"state":          string(run.GetState()),
+			"execution_date": date.Format(time.RFC3339),
+		})
+	}
+	data, _ := json.Marshal(listed)
+	return MWAAData{Stdout: data, StdoutStr: string(data)}
+}
+
+var triggerFlagRegexp = regexp.MustCompile(`--(exec-date|run-id) '([^']*)'`)
+
+// answers `dags list-runs`, `dags trigger` and `tasks states-for-dag-run` from runs, triggered runs succeed at once
+type fakeAirflow struct {
+	mu   sync.Mutex
+	runs []airflow.DAGRun
+	// commands posted
+	posted []string
+}
+
+func (f *fakeAirflow) respond(cmd string) (MWAAData, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posted = append(f.posted, cmd)
+	switch {
+	case strings.HasPrefix(cmd, "dags list-runs"):
+		return listRunsResponse(cmd, f.runs), nil
+	case strings.HasPrefix(cmd, "tasks states-for-dag-run"):
+		return MWAAData{Stdout: []byte("[]"), StdoutStr: "[]"}, nil
+	case strings.HasPrefix(cmd, "dags trigger"):
+		flags := map[string]string{}
+		for _, m := range triggerFlagRegexp.FindAllStringSubmatch(cmd, -1) {
+			flags[m[1]] = m[2]
+		}
+		dagId := cmd[strings.LastIndex(cmd[:len(cmd)-1], "'")+1 : len(cmd)-1]
+		date := time.Now().UTC().Truncate(time.Second)
+		if flags["exec-date"] != "" {
+			var err error
+			if date, err = time.Parse(PythonISONoDecimalTimeLayout, flags["exec-date"]); err != nil {
+				return MWAAData{}, err
+			}
+		}
+		if flags["run-id"] == "" {
+			flags["run-id"] = RunTypeManual + "__" + date.Format(PythonISONoDecimalTimeLayout)
+		}
+		for _, run := range f.runs {
+			if run.GetDagId() == dagId && (run.GetDagRunId() == flags["run-id"] || runLogicalDate(run).Equal(date)) {
+				stderr := "airflow.exceptions.DagRunAlreadyExists: A dag run already exists for dag " + dagId
+				return MWAAData{StderrStr: stderr}, errors.New(stderr)
+			}
+		}
+		run := airflow.NewDAGRun()
+		run.SetDagId(dagId)
+		run.SetDagRunId(flags["run-id"])
+		run.SetExecutionDate(date)
+		run.SetState(airflow.DAGSTATE_SUCCESS)
+		f.runs = append(f.runs, *run)
+		stdout := fmt.Sprintf("[2022-12-01 00:00:00,000] {{__init__.py:38}} INFO - Loaded API auth backend\nCreated <DagRun %s @ %s: %s, externally triggered: True>", dagId, date.Format(PythonISONoDecimalTimeLayout), flags["run-id"])
+		return MWAAData{Stdout: []byte(stdout), StdoutStr: stdout}, nil
+	}
+	return MWAAData{}, fmt.Errorf("unexpected command %s", cmd)
+}
+
+// returns the posted commands starting with prefix
+func (f *fakeAirflow) commands(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmds := []string{}
+	for _, cmd := range f.posted {
+		if strings.HasPrefix(cmd, prefix) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func TestListDagRunsDates(t *testing.T) {
+	var got []string
+	cli := stubClient(func(cmd string) (MWAAData, error) {
+		got = append(got, cmd)
+		return MWAAData{Stdout: []byte("[]")}, nil
+	})
+	// 2022-12-01 21:00 in New York is already the 2nd in UTC
+	newYork := time.FixedZone("EST", -5*60*60)
+	_, err := cli.ListDagRuns(ListDagRunsInput{DagId: "example_dag", StartDate: time.Date(2022, 12, 1, 21, 0, 0, 0, newYork), EndDate: time.Date(2022, 12, 3, 21, 0, 0, 0, newYork)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`dags list-runs --dag-id 'example_dag' --start-date '2022-12-02' --end-date '2022-12-04T23:59:59.999999Z' --output json`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListDagRuns() posted %v, want %v", got, want)
+	}
+}
+
+func TestLogicalDateOf(t *testing.T) {
+	tests := []struct {
+		runId string
+		want  time.Time
+	}{
+		{"scheduled__2022-12-01T00:00:00+00:00", time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"manual__2023-01-02T03:04:05.123456+00:00", time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.UTC)},
+		{"my_run", time.Time{}},
+		{"custom__not_a_date", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := logicalDateOf(tt.runId); !got.Equal(tt.want) {
+			t.Errorf("logicalDateOf(%s) = %v, want %v", tt.runId, got, tt.want)
+		}
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	run := func(runId string, state airflow.DagState) airflow.DAGRun {
+		r := airflow.NewDAGRun()
+		r.SetDagId("example_dag")
+		r.SetDagRunId(runId)
+		r.SetState(state)
+		return *r
+	}
+	now := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	previous := map[string]airflow.DagState{
+		"scheduled__1": airflow.DAGSTATE_RUNNING,
+		"scheduled__2": airflow.DAGSTATE_SUCCESS,
+		"manual__1":    airflow.DAGSTATE_FAILED,
+	}
+	runs := []airflow.DAGRun{
+		run("scheduled__1", airflow.DAGSTATE_FAILED),
+		run("scheduled__2", airflow.DAGSTATE_SUCCESS),
+		run("scheduled__3", airflow.DAGSTATE_QUEUED),
+	}
+	var got []string
+	for _, e := range diffRuns("example_dag", previous, runs, now) {
+		got = append(got, fmt.Sprintf("%s %s %s->%s", e.Type, e.RunId, e.From, e.To))
+	}
+	want := []string{
+		"state_changed scheduled__1 running->failed",
+		"added scheduled__3 ->queued",
+		"removed manual__1 failed->",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffRuns() = %v, want %v", got, want)
+	}
+	// a restarted watcher picks up where the checkpoint left off
+	path := filepath.Join(t.TempDir(), "watcher.json")
+	w := (&CLIENT{}).NewWatcher(WatcherOptions{Checkpoint: path})
+	if err := w.loadCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	w.runs["example_dag"] = previous
+	w.since["example_dag"] = now
+	if err := w.saveCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := (&CLIENT{}).NewWatcher(WatcherOptions{Checkpoint: path})
+	if err := restarted.loadCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restarted.runs, w.runs) || !reflect.DeepEqual(restarted.since, w.since) {
+		t.Errorf("loadCheckpoint() = %v, %v, want %v, %v", restarted.runs, restarted.since, w.runs, w.since)
+	}
+}
+
+func TestWatcherRestart(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	finished := airflow.NewDAGRun()
+	finished.SetDagId("example_dag")
+	finished.SetDagRunId("scheduled__2022-12-01T00:00:00+00:00")
+	finished.SetExecutionDate(day)
+	finished.SetState(airflow.DAGSTATE_SUCCESS)
+	// the logical date of an idempotent run id can't be read back from it
+	runId, _ := IdempotentRunId(TriggerOnceInput{DagId: "example_dag", LogicalDate: day.AddDate(0, 0, 1), Key: "request-1"})
+	running := airflow.NewDAGRun()
+	running.SetDagId("example_dag")
+	running.SetDagRunId(runId)
+	running.SetExecutionDate(day.AddDate(0, 0, 1))
+	running.SetState(airflow.DAGSTATE_RUNNING)
+	fake := &fakeAirflow{runs: []airflow.DAGRun{*finished, *running}}
+	cli := stubClient(fake.respond)
+	opts := WatcherOptions{DagIds: []string{"example_dag"}, Checkpoint: filepath.Join(t.TempDir(), "watcher.json")}
+	w := cli.NewWatcher(opts)
+	if err := w.loadCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	restarted := cli.NewWatcher(opts)
+	if err := restarted.loadCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.events) != 0 {
+		t.Errorf("restarted watcher emitted %+v, want no events", <-restarted.events)
+	}
+	want := []string{
+		`dags list-runs --dag-id 'example_dag' --output json`,
+		`dags list-runs --dag-id 'example_dag' --start-date '2022-12-02' --output json`,
+	}
+	if got := fake.commands("dags list-runs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("watchers listed runs with %v, want %v", got, want)
+	}
+}
+
+func TestWatchWindow(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, 12, d, 6, 0, 0, 0, time.UTC) }
+	run := func(d int, state airflow.DagState) airflow.DAGRun {
+		r := airflow.NewDAGRun()
+		r.SetDagRunId(fmt.Sprintf("scheduled__2022-12-%02dT06:00:00+00:00", d))
+		r.SetLogicalDate(day(d))
+		r.SetState(state)
+		return *r
+	}
+	tests := []struct {
+		name string
+		runs []airflow.DAGRun
+		want time.Time
+	}{
+		{"earliest unfinished run", []airflow.DAGRun{run(3, airflow.DAGSTATE_RUNNING), run(2, airflow.DAGSTATE_QUEUED), run(1, airflow.DAGSTATE_SUCCESS)}, day(2)},
+		{"latest finished run", []airflow.DAGRun{run(3, airflow.DAGSTATE_SUCCESS), run(1, airflow.DAGSTATE_FAILED)}, day(3)},
+		{"no runs", []airflow.DAGRun{}, day(1)},
+	}
+	for _, tt := range tests {
+		if got := nextWatchStart(tt.runs, day(1)); !got.Equal(tt.want) {
+			t.Errorf("%s: nextWatchStart() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	previous := map[string]airflow.DagState{
+		"scheduled__2022-12-01T06:00:00+00:00": airflow.DAGSTATE_SUCCESS,
+		"scheduled__2022-12-02T06:00:00+00:00": airflow.DAGSTATE_SUCCESS,
+		"custom_old":                           airflow.DAGSTATE_SUCCESS,
+		"custom_new":                           airflow.DAGSTATE_RUNNING,
+	}
+	dates := map[string]time.Time{"custom_new": day(3)}
+	want := map[string]airflow.DagState{
+		"scheduled__2022-12-02T06:00:00+00:00": airflow.DAGSTATE_SUCCESS,
+		"custom_new":                           airflow.DAGSTATE_RUNNING,
+	}
+	// listed from the 2nd, so the run at 06:00 that day is still listed
+	if got := runsInWindow(previous, dates, day(2).Add(time.Hour)); !reflect.DeepEqual(got, want) {
+		t.Errorf("runsInWindow() = %v, want %v", got, want)
+	}
+	if got := runsInWindow(previous, dates, time.Time{}); !reflect.DeepEqual(got, previous) {
+		t.Errorf("runsInWindow() without a bound = %v, want %v", got, previous)
+	}
+}
+
+func TestUnmarshalListDagRuns(t *testing.T) {
+	runs, err := UnmarshalListDagRuns(MWAAData{Stdout: []byte(`[
+		{"dag_id": "example_dag", "run_id": "scheduled__2022-11-30T00:00:00+00:00", "state": "running", "execution_date": "2022-11-30T00:00:00+00:00", "start_date": "2022-12-01T00:00:05.123456+00:00", "end_date": ""},
+		{"dag_id": "example_dag", "run_id": "nightly-42", "state": "success", "run_type": "manual", "execution_date": "2022-11-29 00:00:00+00:00", "data_interval_start": "2022-11-28T00:00:00+00:00", "data_interval_end": "2022-11-29T00:00:00+00:00"}
+	]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("UnmarshalListDagRuns() returned %d runs, want 2", len(runs))
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "run type from run id", got: runs[0].GetRunType(), want: RunTypeScheduled},
+		{name: "start date", got: runs[0].GetStartDate(), want: time.Date(2022, 12, 1, 0, 0, 5, 123456000, time.UTC)},
+		{name: "no end date", got: runs[0].HasEndDate(), want: false},
+		{name: "state", got: runs[0].GetState(), want: airflow.DAGSTATE_RUNNING},
+		{name: "run type from output", got: runs[1].GetRunType(), want: RunTypeManual},
+		{name: "python str execution date", got: runs[1].GetExecutionDate(), want: time.Date(2022, 11, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "data interval", got: runs[1].GetDataIntervalStart(), want: time.Date(2022, 11, 28, 0, 0, 0, 0, time.UTC)},
+		{name: "filter by run id", got: len(filterDagRuns(runs, ListDagRunsInput{RunId: "nightly-42"})), want: 1},
+		{name: "limit", got: len(filterDagRuns(runs, ListDagRunsInput{Limit: 1})), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if g, ok := tt.got.(time.Time); ok {
+				if !g.Equal(tt.want.(time.Time)) {
+					t.Errorf("got %v, want %v", tt.got, tt.want)
+				}
+			} else if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeRunStats(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	run := func(offset int, state airflow.DagState, minutes int) airflow.DAGRun {
+		r := airflow.NewDAGRun()
+		date := day.AddDate(0, 0, offset)
+		r.SetExecutionDate(date)
+		r.SetState(state)
+		if minutes > 0 {
+			r.SetStartDate(date)
+			r.SetEndDate(date.Add(time.Duration(minutes) * time.Minute))
+		}
+		return *r
+	}
+	runs := []airflow.DAGRun{
+		// previous window
+		run(-2, airflow.DAGSTATE_SUCCESS, 10),
+		run(-1, airflow.DAGSTATE_SUCCESS, 10),
+		// current window
+		run(0, airflow.DAGSTATE_SUCCESS, 10),
+		run(1, airflow.DAGSTATE_FAILED, 20),
+		run(2, airflow.DAGSTATE_SUCCESS, 30),
+		run(3, airflow.DAGSTATE_SUCCESS, 40),
+		run(3, airflow.DAGSTATE_RUNNING, 0),
+		// after the window
+		run(4, airflow.DAGSTATE_FAILED, 90),
+	}
+	current := ComputeRunStats("example_dag", runs, day, day.AddDate(0, 0, 4))
+	want := RunStats{
+		DagId:        "example_dag",
+		Start:        day,
+		End:          day.AddDate(0, 0, 4),
+		Total:        5,
+		ByState:      map[string]int{"success": 3, "failed": 1, "running": 1},
+		SuccessRate:  0.75,
+		MeanDuration: 25 * time.Minute,
+		P50Duration:  20 * time.Minute,
+		P95Duration:  40 * time.Minute,
+		MaxDuration:  40 * time.Minute,
+	}
+	if !reflect.DeepEqual(current, want) {
+		t.Errorf("ComputeRunStats() = %+v, want %+v", current, want)
+	}
+	trend := CompareRunStats(current, ComputeRunStats("example_dag", runs, day.AddDate(0, 0, -4), day))
+	if trend.SuccessRateDelta != -0.25 || trend.MeanDurationDelta != 15*time.Minute {
+		t.Errorf("CompareRunStats() = %+v, want success rate -0.25 and mean duration +15m", trend)
+	}
+	var b strings.Builder
+	if err := WriteRunTrendsCSV(&b, []RunTrend{trend}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	wantRow := "example_dag,2022-12-01T00:00:00Z,2022-12-05T00:00:00Z,5,3,1,1,0,0.7500,1500.000,1200.000,2400.000,2400.000,2,1.0000,600.000,600.000,-0.2500,900.000,1800.000"
+	if len(lines) != 2 || lines[1] != wantRow {
+		t.Errorf("WriteRunTrendsCSV() = %s, want row %s", b.String(), wantRow)
+	}
+}
+
+func TestGetRunAnalytics(t *testing.T) {
+	run := func(runId string, date time.Time) airflow.DAGRun {
+		r := airflow.NewDAGRun()
+		r.SetDagId("example_dag")
+		r.SetDagRunId(runId)
+		r.SetExecutionDate(date)
+		r.SetState(airflow.DAGSTATE_SUCCESS)
+		return *r
+	}
+	runs := []airflow.DAGRun{
+		run("previous", time.Date(2022, 11, 30, 12, 0, 0, 0, time.UTC)),
+		run("current", time.Date(2022, 12, 1, 6, 0, 0, 0, time.UTC)),
+		// after midnight of End's day, before End
+		run("end_day", time.Date(2022, 12, 2, 6, 0, 0, 0, time.UTC)),
+		run("after", time.Date(2022, 12, 2, 18, 0, 0, 0, time.UTC)),
+	}
+	cli := stubClient(func(cmd string) (MWAAData, error) {
+		return listRunsResponse(cmd, runs), nil
+	})
+	analytics, err := cli.GetRunAnalytics(RunAnalyticsInput{
+		DagIds: []string{"example_dag"},
+		Start:  time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2022, 12, 2, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := analytics.Total.Current.Total; got != 2 {
+		t.Errorf("GetRunAnalytics() current total = %d, want 2", got)
+	}
+	if got := analytics.Total.Previous.Total; got != 1 {
+		t.Errorf("GetRunAnalytics() previous total = %d, want 1", got)
+	}
+}
+
+func TestChunkedBackfillDates(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		input ChunkedBackfillInput
+		want  []time.Time
+		err   bool
+	}{
+		{"daily by default", ChunkedBackfillInput{StartDate: day, EndDate: day.AddDate(0, 0, 2)}, []time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)}, false},
+		{"step", ChunkedBackfillInput{StartDate: day, EndDate: day.Add(13 * time.Hour), Step: 6 * time.Hour}, []time.Time{day, day.Add(6 * time.Hour), day.Add(12 * time.Hour)}, false},
+		{"explicit dates sorted", ChunkedBackfillInput{Dates: []time.Time{day.AddDate(0, 0, 1), day}}, []time.Time{day, day.AddDate(0, 0, 1)}, false},
+		{"missing range", ChunkedBackfillInput{StartDate: day}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.input.dates()
+			if (err != nil) != tt.err {
+				t.Fatalf("dates() error = %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackfillCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backfill.json")
+	checkpoint, err := loadBackfillCheckpoint(path, "example_dag")
+	if err != nil || len(checkpoint.Runs) != 0 {
+		t.Fatalf("loadBackfillCheckpoint() of a missing file = %+v, %v", checkpoint, err)
+	}
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	checkpoint.Runs[day.Format(time.RFC3339)] = BackfillRunResult{LogicalDate: day, RunId: "manual__1", State: airflow.DAGSTATE_SUCCESS}
+	checkpoint.Runs[day.AddDate(0, 0, 1).Format(time.RFC3339)] = BackfillRunResult{LogicalDate: day.AddDate(0, 0, 1), RunId: "manual__2", State: airflow.DAGSTATE_RUNNING}
+	data, _ := json.Marshal(checkpoint)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadBackfillCheckpoint(path, "example_dag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Runs[day.Format(time.RFC3339)].done() || loaded.Runs[day.AddDate(0, 0, 1).Format(time.RFC3339)].done() {
+		t.Errorf("loadBackfillCheckpoint() = %+v, want the first date done and the second in flight", loaded)
+	}
+	if _, err := loadBackfillCheckpoint(path, "other_dag"); err == nil {
+		t.Error("loadBackfillCheckpoint() of another DAG's checkpoint, want error")
+	}
+}
+
+func TestChunkedBackfillResume(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	// the first date was triggered, then the backfill crashed before recording it
+	runId, _ := IdempotentRunId(TriggerOnceInput{DagId: "example_dag", LogicalDate: day})
+	run := airflow.NewDAGRun()
+	run.SetDagId("example_dag")
+	run.SetDagRunId(runId)
+	run.SetExecutionDate(day)
+	run.SetState(airflow.DAGSTATE_SUCCESS)
+	fake := &fakeAirflow{runs: []airflow.DAGRun{*run}}
+	cli := stubClient(fake.respond)
+	summary, err := cli.ChunkedBackfill(context.Background(), ChunkedBackfillInput{
+		DagId:      "example_dag",
+		StartDate:  day,
+		EndDate:    day.AddDate(0, 0, 1),
+		Checkpoint: filepath.Join(t.TempDir(), "backfill.json"),
+		Wait:       DagRunWaitOptions{PollInterval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Succeeded != 2 || summary.Errored != 0 {
+		t.Errorf("ChunkedBackfill() = %+v, want both dates succeeded", summary)
+	}
+	if summary.Runs[0].RunId != runId {
+		t.Errorf("ChunkedBackfill() waited on %s, want the existing run %s", summary.Runs[0].RunId, runId)
+	}
+	if triggers := fake.commands("dags trigger"); len(triggers) != 1 {
+		t.Errorf("ChunkedBackfill() posted %v, want only the second date triggered", triggers)
+	}
+}
+
+func TestIdempotentRunId(t *testing.T) {
+	day := time.Date(2022, 12, 1, 6, 0, 0, 0, time.UTC)
+	base := TriggerOnceInput{DagId: "example_dag", LogicalDate: day, Conf: map[string]interface{}{"a": 1, "b": "x"}}
+	baseId, err := IdempotentRunId(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(baseId, "manual__2022-12-01T06:00:00+00:00_") || RunTypeOf(baseId) != RunTypeManual {
+		t.Errorf("IdempotentRunId() = %s, want a manual run id at the logical date", baseId)
+	}
+	tests := []struct {
+		name  string
+		input TriggerOnceInput
+		same  bool
+	}{
+		{"same conf", TriggerOnceInput{DagId: "example_dag", LogicalDate: day.In(time.FixedZone("EST", -5*3600)), Conf: map[string]interface{}{"b": "x", "a": 1}}, true},
+		{"other conf", TriggerOnceInput{DagId: "example_dag", LogicalDate: day, Conf: map[string]interface{}{"a": 2, "b": "x"}}, false},
+		{"other date", TriggerOnceInput{DagId: "example_dag", LogicalDate: day.Add(time.Hour), Conf: base.Conf}, false},
+		{"other dag", TriggerOnceInput{DagId: "other_dag", LogicalDate: day, Conf: base.Conf}, false},
+		{"key", TriggerOnceInput{DagId: "example_dag", LogicalDate: day, Conf: base.Conf, Key: "request-1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := IdempotentRunId(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == baseId) != tt.same {
+				t.Errorf("IdempotentRunId() = %s, base %s, want same %v", got, baseId, tt.same)
+			}
+		})
+	}
+	if _, err := IdempotentRunId(TriggerOnceInput{DagId: "example_dag"}); err == nil {
+		t.Error("IdempotentRunId() without a logical date, want error")
+	}
+}
+
+func TestTriggerDagRunOnce(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	scheduled := airflow.NewDAGRun()
+	scheduled.SetDagId("example_dag")
+	scheduled.SetDagRunId("scheduled__2022-12-01T00:00:00+00:00")
+	scheduled.SetExecutionDate(day)
+	scheduled.SetState(airflow.DAGSTATE_SUCCESS)
+	fake := &fakeAirflow{runs: []airflow.DAGRun{*scheduled}}
+	cli := stubClient(fake.respond)
+	tests := []struct {
+		name        string
+		date        time.Time
+		wantCreated bool
+		wantErr     error
+	}{
+		// only the idempotent run id counts as this trigger
+		{name: "another run at the logical date", date: day, wantErr: ErrDagRunExists},
+		{name: "new", date: day.AddDate(0, 0, 1), wantCreated: true},
+		{name: "retried", date: day.AddDate(0, 0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := TriggerOnceInput{DagId: "example_dag", LogicalDate: tt.date, Key: "request-1"}
+			run, created, err := cli.TriggerDagRunOnce(input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TriggerDagRunOnce() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			runId, _ := IdempotentRunId(input)
+			if created != tt.wantCreated || run.GetDagRunId() != runId {
+				t.Errorf("TriggerDagRunOnce() = %s, %v, want %s, %v", run.GetDagRunId(), created, runId, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestIsDagRunConflict(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"airflow.exceptions.DagRunAlreadyExists: A Dag Run already exists for dag id example_dag at 2022-12-01T00:00:00+00:00 with run id manual__x", true},
+		{"airflow.exceptions.DagRunAlreadyExists: Run id manual__x already exists for dag id example_dag", true},
+		{`psycopg2.errors.UniqueViolation: duplicate key value violates unique constraint "dag_run_dag_id_run_id_key"`, true},
+		{"airflow.exceptions.DagNotFound: Dag id example_dag not found", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stderr, func(t *testing.T) {
+			if got := isDagRunConflict(tt.stderr); got != tt.want {
+				t.Errorf("isDagRunConflict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["table", "mode"],
+		"additionalProperties": false,
+		"properties": {
+			"table": {"type": "string", "pattern": "^[a-z_]+$", "maxLength": 10},
+			"mode": {"enum": ["full", "incremental"]},
+			"batch": {"type": "integer", "minimum": 1, "maximum": 100},
+			"ratio": {"type": ["number", "null"], "exclusiveMaximum": 1},
+			"columns": {"type": "array", "minItems": 1, "items": {"type": "string"}},
+			"env": {"oneOf": [{"const": "dev"}, {"const": "prod"}]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		conf map[string]interface{}
+		want []string
+	}{
+		{"valid", map[string]interface{}{"table": "sales", "mode": "full", "batch": 10, "ratio": nil, "columns": []string{"id"}, "env": "dev"}, []string{}},
+		{"missing required", map[string]interface{}{}, []string{"$.table is required", "$.mode is required"}},
+		{"every field wrong", map[string]interface{}{
+			"table":   "Sales-Table",
+			"mode":    "partial",
+			"batch":   1.5,
+			"ratio":   1,
+			"columns": []interface{}{"id", 2},
+			"env":     "qa",
+			"extra":   true,
+		}, []string{
+			"$.batch is number, want integer",
+			"$.columns[1] is integer, want string",
+			"$.env matches 0 of oneOf, want exactly 1",
+			"$.extra is not an allowed property",
+			"$.mode partial is not one of [full incremental]",
+			"$.ratio 1 is not less than 1",
+			"$.table is 11 characters long, longer than 10",
+			`$.table "Sales-Table" does not match ^[a-z_]+$`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := schema.Validate(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, v := range violations {
+				got = append(got, v.Path+" "+v.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConf(t *testing.T) {
+	cli := &CLIENT{}
+	params := map[string]json.RawMessage{
+		"table": json.RawMessage(`{"__class": "airflow.models.param.Param", "value": null, "schema": {"type": "string"}}`),
+		"limit": json.RawMessage(`{"__class": "airflow.models.param.Param", "value": 10, "schema": {"type": "integer"}}`),
+		"note":  json.RawMessage(`{"__class": "airflow.models.param.Param", "value": null, "schema": {"type": ["null", "string"]}}`),
+	}
+	cli.RegisterConfSchema("example_dag", paramsSchema(params))
+	tests := []struct {
+		name  string
+		dagId string
+		conf  map[string]interface{}
+		err   bool
+	}{
+		{"valid", "example_dag", map[string]interface{}{"table": "sales", "other": 1}, false},
+		{"missing param without default", "example_dag", nil, true},
+		{"wrong type", "example_dag", map[string]interface{}{"table": "sales", "limit": "ten"}, true},
+		{"no schema", "other_dag", map[string]interface{}{"limit": "ten"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cli.validateConf(tt.dagId, tt.conf)
+			if (err != nil) != tt.err || (err != nil && !errors.Is(err, ErrInvalidConf)) {
+				t.Errorf("validateConf() = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+	cli.RegisterConfSchema("example_dag", nil)
+	if _, ok := cli.ConfSchema("example_dag"); ok {
+		t.Error("RegisterConfSchema(nil) did not remove the schema")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		calls     int
+		min       time.Duration
+	}{
+		{"unlimited", 0, 5, 0},
+		{"100 per second", 100, 5, 40 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.perSecond)
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < tt.calls; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := limiter.wait(context.Background()); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.min+time.Second {
+				t.Errorf("%d calls took %v, want about %v", tt.calls, elapsed, tt.min)
+			}
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newRateLimiter(1).wait(ctx); err == nil {
+		t.Error("wait() on a cancelled context, want error")
+	}
+}
+
+func TestTriggerDagRuns(t *testing.T) {
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeAirflow{}
+	cli := stubClient(fake.respond)
+	var specs []airflow.DAGRun
+	for _, date := range []time.Time{day.AddDate(0, 0, 2), day, day.AddDate(0, 0, 1)} {
+		spec := airflow.NewDAGRun()
+		spec.SetDagId("example_dag")
+		spec.SetExecutionDate(date)
+		specs = append(specs, *spec)
+	}
+	// a second run at the same logical date fails to trigger
+	specs = append(specs, specs[1])
+	summary, err := cli.TriggerDagRuns(context.Background(), specs, BulkTriggerOptions{
+		Concurrency: 1,
+		Wait:        true,
+		WaitOptions: DagRunWaitOptions{PollInterval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Triggered != 3 || summary.TriggerFailed != 1 || summary.Succeeded != 3 || summary.Unfinished != 0 {
+		t.Errorf("TriggerDagRuns() = %+v, want 3 succeeded and 1 failed to trigger", summary)
+	}
+	want := []string{`dags list-runs --dag-id 'example_dag' --start-date '2022-12-01' --output json`}
+	if got := fake.commands("dags list-runs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("TriggerDagRuns() listed runs with %v, want %v", got, want)
+	}
+	// a run deleted after the trigger is given up on rather than polled forever
+	deleted := &fakeAirflow{}
+	cli = stubClient(func(cmd string) (MWAAData, error) {
+		data, err := deleted.respond(cmd)
+		if strings.HasPrefix(cmd, "dags list-runs") {
+			return MWAAData{Stdout: []byte("[]"), StdoutStr: "[]"}, nil
+		}
+		return data, err
+	})
+	summary, err = cli.TriggerDagRuns(context.Background(), specs[:1], BulkTriggerOptions{
+		Wait:        true,
+		WaitOptions: DagRunWaitOptions{PollInterval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls := len(deleted.commands("dags list-runs")); summary.Unfinished != 1 || summary.Results[0].Error == "" || polls != missingRunPolls {
+		t.Errorf("TriggerDagRuns() of a deleted run = %+v after %d polls, want it unfinished with an error after %d", summary, polls, missingRunPolls)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	data := MWAAData{
+		StderrStr: "[INFO] Please be reminded this DAG is PAUSED now.",
+		StdoutStr: "2022-12-01T00:00:00+00:00\n2022-12-01T06:00:00+00:00\n2022-12-01T12:00:00+00:00\n2022-12-01T18:00:00+00:00",
+	}
+	dates, err := ParseNextExecutions(data)
+	if err != nil || len(dates) != 4 {
+		t.Fatalf("ParseNextExecutions() = %v, %v, want 4 dates", dates, err)
+	}
+	if dates, _ := ParseNextExecutions(MWAAData{StdoutStr: "None"}); len(dates) != 0 {
+		t.Errorf("ParseNextExecutions(None) = %v, want none", dates)
+	}
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	forecast := Forecast{Start: day, End: day.Add(24 * time.Hour)}
+	forecast.Runs = append(forecastDag(NextExecutions{DagId: "six_hourly", LogicalDates: dates}, forecast.Start, forecast.End),
+		forecastDag(NextExecutions{DagId: "daily", LogicalDates: []time.Time{day.Add(-24 * time.Hour), day, day.Add(24 * time.Hour)}}, forecast.Start, forecast.End)...)
+	sortForecastRuns(forecast.Runs)
+	got := []string{}
+	for _, run := range forecast.Runs {
+		got = append(got, run.DagId+"@"+run.StartsAt.Format("15:04"))
+	}
+	want := []string{"daily@00:00", "six_hourly@06:00", "six_hourly@12:00", "six_hourly@18:00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("forecast runs = %v, want %v", got, want)
+	}
+	tests := []struct {
+		name      string
+		minLength time.Duration
+		margin    time.Duration
+		want      []TimeWindow
+	}{
+		{"no margin", 6 * time.Hour, 0, []TimeWindow{
+			{day, day.Add(6 * time.Hour)},
+			{day.Add(6 * time.Hour), day.Add(12 * time.Hour)},
+			{day.Add(12 * time.Hour), day.Add(18 * time.Hour)},
+			{day.Add(18 * time.Hour), day.Add(24 * time.Hour)},
+		}},
+		{"margin", 4 * time.Hour, time.Hour, []TimeWindow{
+			{day.Add(time.Hour), day.Add(5 * time.Hour)},
+			{day.Add(7 * time.Hour), day.Add(11 * time.Hour)},
+			{day.Add(13 * time.Hour), day.Add(17 * time.Hour)},
+			{day.Add(19 * time.Hour), day.Add(24 * time.Hour)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := forecast.FreeWindows(tt.minLength, tt.margin)
+			equal := len(got) == len(tt.want)
+			for i := 0; equal && i < len(got); i++ {
+				equal = got[i].Start.Equal(tt.want[i].Start) && got[i].End.Equal(tt.want[i].End)
+			}
+			if !equal {
+				t.Errorf("FreeWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if collisions := forecast.Collisions(TimeWindow{day.Add(5 * time.Hour), day.Add(7 * time.Hour)}, 0); len(collisions) != 1 || collisions[0].DagId != "six_hourly" {
+		t.Errorf("Collisions() = %v, want the 06:00 run", collisions)
+	}
+	// airflow 2.0 only gives the next logical date
+	fake := &fakeAirflow{}
+	cli := stubClient(fake.respond)
+	cli.SetAirflowVersion(Version{Major: 2, Minor: 0, Patch: 2})
+	if _, err := cli.ForecastRuns(ForecastInput{DagIds: []string{"daily"}, Start: day, End: day.Add(24 * time.Hour)}); !errors.Is(err, ErrUnsupportedByVersion) || len(fake.posted) != 0 {
+		t.Errorf("ForecastRuns() on 2.0.2 = %v after posting %v, want ErrUnsupportedByVersion before posting", err, fake.posted)
+	}
+}
+
+const testDagTestLog = `[2023-01-01T12:00:00.000+0000] {dag.py:3584} INFO - dagrun id: example_dag
+[2023-01-01T12:00:00.100+0000] {dag.py:3539} INFO - Running task extract
+[2023-01-01T12:00:05.000+0000] {taskinstance.py:1327} INFO - Marking task as SUCCESS. dag_id=example_dag, task_id=extract, execution_date=20230101T000000, start_date=20230101T120000, end_date=20230101T120005
+[2023-01-01T12:00:05.100+0000] {python.py:177} INFO - Done. Returned value was: None
+[2023-01-01T12:00:06.000+0000] {taskinstance.py:1851} ERROR - Task failed with exception
+Traceback (most recent call last):
+  File "/usr/local/airflow/dags/example_dag.py", line 12, in transform
+    raise ValueError("bad row 42")
+ValueError: bad row 42
+[2023-01-01T12:00:06.100+0000] {taskinstance.py:1401} INFO - Marking task as FAILED. dag_id=example_dag, task_id=transform, execution_date=20230101T000000, start_date=20230101T120005, end_date=20230101T120006
+[2023-01-01T12:00:06.200+0000] {taskinstance.py:1401} INFO - Marking task as SKIPPED. dag_id=example_dag, task_id=notify, execution_date=20230101T000000, start_date=20230101T120006, end_date=20230101T120006
+[2023-01-01T12:00:07.000+0000] {dagrun.py:585} ERROR - Marking run <DagRun example_dag @ 2023-01-01 00:00:00+00:00: manual__2023-01-01T00:00:00+00:00, state:running, queued_at: None. externally triggered: False> failed`
+
+func TestParseDagTestLog(t *testing.T) {
+	result := ParseDagTestLog(testDagTestLog)
+	if result.DagId != "example_dag" || result.State != airflow.DAGSTATE_FAILED || !result.LogicalDate.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseDagTestLog() = %s %s %v, want example_dag failed 2023-01-01", result.DagId, result.State, result.LogicalDate)
+	}
+	tests := []struct {
+		taskId    string
+		state     airflow.TaskState
+		duration  time.Duration
+		exception string
+	}{
+		{"extract", airflow.TASKSTATE_SUCCESS, 5 * time.Second, ""},
+		{"transform", airflow.TASKSTATE_FAILED, time.Second, "ValueError: bad row 42"},
+		{"notify", airflow.TASKSTATE_SKIPPED, 0, ""},
+	}
+	if len(result.Tasks) != len(tests) {
+		t.Fatalf("ParseDagTestLog() tasks = %+v, want %d", result.Tasks, len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.taskId, func(t *testing.T) {
+			task := result.Tasks[i]
+			if task.TaskId != tt.taskId || task.State != tt.state || task.Duration != tt.duration || task.Exception != tt.exception || task.Tries != 1 {
+				t.Errorf("task = %+v, want %s %s %v %q", task, tt.taskId, tt.state, tt.duration, tt.exception)
+			}
+		})
+	}
+	if result.Succeeded() || len(result.FailedTasks()) != 1 {
+		t.Errorf("Succeeded() = %v, FailedTasks() = %+v, want false and transform", result.Succeeded(), result.FailedTasks())
+	}
+}
+
+// answers GetEnvironment with each of envs in turn, repeating the last, and records UpdateEnvironment calls
+type fakeEnvironments struct {
+	mu      sync.Mutex
+	envs    []*mwaa.Environment
+	gets    int
+	updates []mwaa.UpdateEnvironmentInput
+	// environments answered after each UpdateEnvironment call, in call order
+	afterUpdate [][]*mwaa.Environment
+}
+
+func (f *fakeEnvironments) GetEnvironmentWithContext(ctx aws.Context, input *mwaa.GetEnvironmentInput, opts ...request.Option) (*mwaa.GetEnvironmentOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.gets
+	if i >= len(f.envs) {
+		i = len(f.envs) - 1
+	}
+	f.gets++
+	return &mwaa.GetEnvironmentOutput{Environment: f.envs[i]}, nil
+}
+
+func (f *fakeEnvironments) UpdateEnvironmentWithContext(ctx aws.Context, input *mwaa.UpdateEnvironmentInput, opts ...request.Option) (*mwaa.UpdateEnvironmentOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, *input)
+	if len(f.afterUpdate) > 0 {
+		f.envs, f.gets, f.afterUpdate = f.afterUpdate[0], 0, f.afterUpdate[1:]
+	}
+	return &mwaa.UpdateEnvironmentOutput{}, nil
+}
+
+// an environment in status whose last update was created at createdAt with updateStatus
+func testEnvironment(status string, createdAt time.Time, updateStatus string) *mwaa.Environment {
+	env := &mwaa.Environment{Name: aws.String("test"), Status: aws.String(status)}
+	if !createdAt.IsZero() {
+		env.LastUpdate = &mwaa.LastUpdate{CreatedAt: aws.Time(createdAt), Status: aws.String(updateStatus)}
+		if updateStatus == mwaa.UpdateStatusFailed {
+			env.LastUpdate.Error = &mwaa.UpdateError{ErrorCode: aws.String("INVALID_REQUIREMENTS"), ErrorMessage: aws.String("pip install failed")}
+		}
+	}
+	return env
+}
+
+func TestWaitForEnvironment(t *testing.T) {
+	since := time.Now()
+	old := since.Add(-24 * time.Hour)
+	tests := []struct {
+		name     string
+		since    time.Time
+		envs     []*mwaa.Environment
+		wantGets int
+		err      bool
+	}{
+		{"stale available before the update starts", since, []*mwaa.Environment{
+			testEnvironment(mwaa.EnvironmentStatusAvailable, old, mwaa.UpdateStatusSuccess),
+			testEnvironment(mwaa.EnvironmentStatusUpdating, since, mwaa.UpdateStatusPending),
+			testEnvironment(mwaa.EnvironmentStatusAvailable, since, mwaa.UpdateStatusSuccess),
+		}, 3, false},
+		{"rolled back after a failed update", since, []*mwaa.Environment{
+			testEnvironment(mwaa.EnvironmentStatusUpdating, since, mwaa.UpdateStatusPending),
+			testEnvironment(mwaa.EnvironmentStatusRollingBack, since, mwaa.UpdateStatusPending),
+			testEnvironment(mwaa.EnvironmentStatusCreatingSnapshot, since, mwaa.UpdateStatusPending),
+			testEnvironment(mwaa.EnvironmentStatusAvailable, since, mwaa.UpdateStatusFailed),
+		}, 4, true},
+		{"update already finished", since, []*mwaa.Environment{
+			testEnvironment(mwaa.EnvironmentStatusAvailable, since.Add(time.Second), mwaa.UpdateStatusSuccess),
+		}, 1, false},
+		{"old failure without a wait time", time.Time{}, []*mwaa.Environment{
+			testEnvironment(mwaa.EnvironmentStatusAvailable, old, mwaa.UpdateStatusFailed),
+		}, 1, false},
+		{"update failed status", since, []*mwaa.Environment{
+			testEnvironment(mwaa.EnvironmentStatusUpdating, since, mwaa.UpdateStatusPending),
+			testEnvironment(mwaa.EnvironmentStatusUpdateFailed, since, mwaa.UpdateStatusFailed),
+		}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := &fakeEnvironments{envs: tt.envs}
+			cli := &CLIENT{Name: aws.String("test"), envAPI: envs}
+			_, err := cli.WaitForEnvironment(context.Background(), EnvironmentWaitOptions{PollInterval: time.Millisecond, Since: tt.since})
+			if (err != nil) != tt.err {
+				t.Errorf("WaitForEnvironment() error = %v, want error %v", err, tt.err)
+			}
+			if envs.gets != tt.wantGets {
+				t.Errorf("WaitForEnvironment() polled %d times, want %d", envs.gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestRollout(t *testing.T) {
+	now := time.Now()
+	current := testEnvironment(mwaa.EnvironmentStatusAvailable, now.Add(-24*time.Hour), mwaa.UpdateStatusSuccess)
+	current.SourceBucketArn = aws.String("arn:aws:s3:::example-bucket")
+	current.RequirementsS3Path = aws.String("requirements.txt")
+	current.RequirementsS3ObjectVersion = aws.String("previous")
+	updated := []*mwaa.Environment{
+		testEnvironment(mwaa.EnvironmentStatusUpdating, now, mwaa.UpdateStatusPending),
+		testEnvironment(mwaa.EnvironmentStatusAvailable, now, mwaa.UpdateStatusSuccess),
+	}
+	rolledBack := []*mwaa.Environment{
+		testEnvironment(mwaa.EnvironmentStatusUpdating, now, mwaa.UpdateStatusPending),
+		testEnvironment(mwaa.EnvironmentStatusRollingBack, now, mwaa.UpdateStatusPending),
+		testEnvironment(mwaa.EnvironmentStatusAvailable, now, mwaa.UpdateStatusFailed),
+	}
+	tests := []struct {
+		name         string
+		afterUpdate  [][]*mwaa.Environment
+		checkErr     error
+		wantChecked  bool
+		wantUpdates  []string
+		wantRollback bool
+	}{
+		{"checks pass", [][]*mwaa.Environment{updated}, nil, true, []string{"new"}, false},
+		{"check fails and rolls back", [][]*mwaa.Environment{updated, updated}, errors.New("boom"), true, []string{"new", "previous"}, true},
+		{"update failed", [][]*mwaa.Environment{rolledBack}, nil, false, []string{"new"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := &fakeEnvironments{envs: []*mwaa.Environment{current}, afterUpdate: tt.afterUpdate}
+			cli := &CLIENT{Name: aws.String("test"), envAPI: envs}
+			store := NewMemoryObjectStore()
+			checked := false
+			result, err := cli.Rollout(context.Background(), RolloutInput{
+				Kind:  ArtifactRequirements,
+				Body:  []byte("boto3\n"),
+				Store: store,
+				Checks: []RolloutCheck{{Name: "stub", Run: func(cli *CLIENT) error {
+					checked = true
+					return tt.checkErr
+				}}},
+				Wait: EnvironmentWaitOptions{PollInterval: time.Millisecond},
+			})
+			if (err != nil) != (tt.checkErr != nil || !tt.wantChecked) {
+				t.Errorf("Rollout() error = %v", err)
+			}
+			if checked != tt.wantChecked || result.RolledBack != tt.wantRollback {
+				t.Errorf("Rollout() checked = %v, rolled back = %v, want %v, %v", checked, result.RolledBack, tt.wantChecked, tt.wantRollback)
+			}
+			versions := []string{}
+			for _, update := range envs.updates {
+				version := aws.StringValue(update.RequirementsS3ObjectVersion)
+				if version == result.NewVersion {
+					version = "new"
+				}
+				versions = append(versions, version)
+			}
+			if !reflect.DeepEqual(versions, tt.wantUpdates) {
+				t.Errorf("Rollout() updated to versions %v, want %v", versions, tt.wantUpdates)
+			}
+		})
+	}
+}
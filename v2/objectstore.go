@@ -0,0 +1,184 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// an object in an ObjectStore
+type ObjectInfo struct {
+	Key       string
+	VersionId string
+	// hex md5 of the content, without surrounding quotes
+	ETag string
+	Size int64
+}
+
+// the S3 operations mwaah uses to ship dags, requirements, plugins and startup scripts
+// buckets are expected to have versioning enabled, as MWAA requires
+type ObjectStore interface {
+	// uploads body to bucket/key and returns the new object version
+	PutObject(ctx context.Context, bucket string, key string, body []byte) (string, error)
+	// lists the latest version of every object under prefix
+	ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error)
+	DeleteObject(ctx context.Context, bucket string, key string) error
+}
+
+// returns the bucket name from an S3 bucket arn, e.g. arn:aws:s3:::my-airflow-bucket
+func BucketFromArn(arn string) (string, error) {
+	const prefix = "arn:aws:s3:::"
+	if !strings.HasPrefix(arn, prefix) || len(arn) == len(prefix) {
+		return "", fmt.Errorf("'%s' is not an S3 bucket arn", arn)
+	}
+	return strings.SplitN(strings.TrimPrefix(arn, prefix), "/", 2)[0], nil
+}
+
+// hex md5 of body, comparable with ObjectInfo.ETag for objects not uploaded in parts
+func ContentETag(body []byte) string {
+	sum := md5.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ObjectStore backed by S3
+type S3ObjectStore struct {
+	svc s3iface.S3API
+}
+
+// creates an ObjectStore from an S3 client, e.g. s3.New(sess)
+func NewS3ObjectStore(svc s3iface.S3API) *S3ObjectStore {
+	return &S3ObjectStore{svc: svc}
+}
+
+func (s *S3ObjectStore) PutObject(ctx context.Context, bucket string, key string, body []byte) (string, error) {
+	out, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.VersionId == nil {
+		return "", fmt.Errorf("bucket %s returned no object version, MWAA requires versioning to be enabled", bucket)
+	}
+	return *out.VersionId, nil
+}
+
+func (s *S3ObjectStore) ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:  aws.StringValue(o.Key),
+				ETag: strings.Trim(aws.StringValue(o.ETag), `"`),
+				Size: aws.Int64Value(o.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return []ObjectInfo{}, err
+	}
+	return objects, nil
+}
+
+func (s *S3ObjectStore) DeleteObject(ctx context.Context, bucket string, key string) error {
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// in memory, versioned ObjectStore standing in for S3 in tests and dry runs
+type MemoryObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]map[string][]memoryObject
+	nextId  int
+}
+
+type memoryObject struct {
+	versionId string
+	body      []byte
+	deleted   bool
+}
+
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{objects: map[string]map[string][]memoryObject{}}
+}
+
+func (m *MemoryObjectStore) PutObject(ctx context.Context, bucket string, key string, body []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects[bucket] == nil {
+		m.objects[bucket] = map[string][]memoryObject{}
+	}
+	m.nextId++
+	versionId := fmt.Sprintf("v%d", m.nextId)
+	m.objects[bucket][key] = append(m.objects[bucket][key], memoryObject{
+		versionId: versionId,
+		body:      append([]byte{}, body...),
+	})
+	return versionId, nil
+}
+
+func (m *MemoryObjectStore) ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []ObjectInfo
+	for key, versions := range m.objects[bucket] {
+		latest := versions[len(versions)-1]
+		if !strings.HasPrefix(key, prefix) || latest.deleted {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:       key,
+			VersionId: latest.versionId,
+			ETag:      ContentETag(latest.body),
+			Size:      int64(len(latest.body)),
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *MemoryObjectStore) DeleteObject(ctx context.Context, bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	versions := m.objects[bucket][key]
+	if len(versions) == 0 {
+		return nil
+	}
+	m.nextId++
+	m.objects[bucket][key] = append(versions, memoryObject{versionId: fmt.Sprintf("v%d", m.nextId), deleted: true})
+	return nil
+}
+
+// returns the content of a specific object version
+func (m *MemoryObjectStore) GetObjectVersion(bucket string, key string, versionId string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.objects[bucket][key] {
+		if o.versionId == versionId && !o.deleted {
+			return o.body, nil
+		}
+	}
+	return nil, errors.New("no such object version: " + bucket + "/" + key + "@" + versionId)
+}
@@ -0,0 +1,302 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// sets the State of every task found in states, e.g. from GetTaskStatesDetailed
+func (g *DagGraph) ApplyStates(states []TaskStatesDetailed) {
+	byTask := map[string]string{}
+	for _, s := range states {
+		if s.TaskId != nil {
+			byTask[*s.TaskId] = s.State
+		}
+	}
+	for i := range g.Nodes {
+		if state, ok := byTask[g.Nodes[i].TaskId]; ok {
+			g.Nodes[i].State = state
+		}
+	}
+}
+
+// returns the task graph of a DAG, with every task's state in the dag run runId
+func (cli *CLIENT) GetDagRunGraph(dagId string, runId string) (*DagGraph, error) {
+	g, err := cli.GetDagGraph(dagId)
+	if err != nil {
+		return nil, err
+	}
+	states, err := cli.GetTaskStatesDetailed(dagId, airflow.NullableTime{}, *airflow.NewNullableString(&runId))
+	if err != nil {
+		return nil, err
+	}
+	g.ApplyStates(states)
+	return g, nil
+}
+
+var diagramIdRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// assigns every task and group an id safe to use in diagram sources, prefixed so ids like "end" can't clash with keywords
+func diagramIds(g *DagGraph) map[string]string {
+	ids := map[string]string{}
+	used := map[string]bool{}
+	add := func(key string, name string) {
+		id := diagramIdRegexp.ReplaceAllString(name, "_")
+		for base, i := id, 2; used[id]; i++ {
+			id = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[id] = true
+		ids[key] = id
+	}
+	for _, grp := range g.Groups {
+		add("group:"+grp.Id, "group_"+grp.Id)
+	}
+	for _, n := range g.Nodes {
+		add(n.TaskId, "task_"+n.TaskId)
+	}
+	return ids
+}
+
+// returns the text a task is drawn with
+func nodeLabel(n DagGraphNode) string {
+	if n.Label != "" {
+		return n.Label
+	}
+	return n.TaskId
+}
+
+// returns the tasks and child groups directly inside group, "" being the top level
+func groupMembers(g *DagGraph, group string) ([]DagGraphNode, []DagGroup) {
+	var nodes []DagGraphNode
+	for _, n := range g.Nodes {
+		if n.Group == group {
+			nodes = append(nodes, n)
+		}
+	}
+	var groups []DagGroup
+	for _, grp := range g.Groups {
+		if grp.Parent == group {
+			groups = append(groups, grp)
+		}
+	}
+	return nodes, groups
+}
+
+// returns the states present in g, sorted
+func graphStates(g *DagGraph) []string {
+	seen := map[string]bool{}
+	states := []string{}
+	for _, n := range g.Nodes {
+		if n.State != "" && !seen[n.State] {
+			seen[n.State] = true
+			states = append(states, n.State)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// returns the color a state is drawn with
+func stateColor(state string) string {
+	if color, ok := stateColors[state]; ok {
+		return color
+	}
+	return "white"
+}
+
+/*
+WriteMermaid writes g as a Mermaid flowchart
+
+Task groups become subgraphs and tasks with a State are colored the way airflow colors them.
+*/
+func WriteMermaid(w io.Writer, g *DagGraph) error {
+	ids := diagramIds(g)
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	escape := strings.NewReplacer(`"`, "#quot;")
+	var writeGroup func(group string, indent string)
+	writeGroup = func(group string, indent string) {
+		nodes, groups := groupMembers(g, group)
+		for _, grp := range groups {
+			label := grp.Label
+			if label == "" {
+				label = grp.Id
+			}
+			fmt.Fprintf(&b, "%ssubgraph %s [\"%s\"]\n", indent, ids["group:"+grp.Id], escape.Replace(label))
+			writeGroup(grp.Id, indent+"    ")
+			fmt.Fprintf(&b, "%send\n", indent)
+		}
+		for _, n := range nodes {
+			label := escape.Replace(nodeLabel(n))
+			if n.Operator != "" {
+				label += "<br/><i>" + escape.Replace(n.Operator) + "</i>"
+			}
+			fmt.Fprintf(&b, "%s%s[\"%s\"]\n", indent, ids[n.TaskId], label)
+		}
+	}
+	writeGroup("", "    ")
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "    %s --> %s\n", ids[e.From], ids[e.To])
+	}
+	for _, state := range graphStates(g) {
+		var members []string
+		for _, n := range g.Nodes {
+			if n.State == state {
+				members = append(members, ids[n.TaskId])
+			}
+		}
+		fmt.Fprintf(&b, "    classDef %s fill:%s\n", state, stateColor(state))
+		fmt.Fprintf(&b, "    class %s %s\n", strings.Join(members, ","), state)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+/*
+WritePlantUML writes g as a PlantUML activity diagram
+
+Task groups become partitions and tasks with a State are colored the way airflow colors them.
+The graph must be acyclic.
+*/
+func WritePlantUML(w io.Writer, g *DagGraph) error {
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		return err
+	}
+	ids := diagramIds(g)
+	_, upstream := g.adjacency()
+	nodes := map[string]DagGraphNode{}
+	for _, n := range g.Nodes {
+		nodes[n.TaskId] = n
+	}
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	if states := graphStates(g); len(states) > 0 {
+		b.WriteString("skinparam activity {\n")
+		for _, state := range states {
+			fmt.Fprintf(&b, "    BackgroundColor<<%s>> %s\n", state, stateColor(state))
+		}
+		b.WriteString("}\n")
+	}
+	escape := strings.NewReplacer(`"`, `'`)
+	// the legacy activity syntax declares a task on the first arrow leading to it, so tasks are declared in dependency order
+	declare := func(indent string, from string, n DagGraphNode) {
+		label := escape.Replace(nodeLabel(n))
+		if n.Operator != "" {
+			label += `\n` + escape.Replace(n.Operator)
+		}
+		stereotype := ""
+		if n.State != "" {
+			stereotype = fmt.Sprintf(" <<%s>>", n.State)
+		}
+		fmt.Fprintf(&b, "%s%s --> \"%s\" as %s%s\n", indent, from, label, ids[n.TaskId], stereotype)
+	}
+	groups := map[string]DagGroup{}
+	for _, grp := range g.Groups {
+		groups[grp.Id] = grp
+	}
+	// enclosing groups of a task, outermost first
+	groupPath := func(group string) []string {
+		var path []string
+		for group != "" {
+			path = append([]string{group}, path...)
+			group = groups[group].Parent
+		}
+		return path
+	}
+	// tasks are declared in dependency order, so a partition is reopened when its tasks are not contiguous
+	open := []string{}
+	for _, id := range order {
+		n := nodes[id]
+		path := groupPath(n.Group)
+		common := 0
+		for common < len(open) && common < len(path) && open[common] == path[common] {
+			common++
+		}
+		for len(open) > common {
+			open = open[:len(open)-1]
+			fmt.Fprintf(&b, "%s}\n", strings.Repeat("    ", len(open)))
+		}
+		for _, group := range path[common:] {
+			label := groups[group].Label
+			if label == "" {
+				label = group
+			}
+			fmt.Fprintf(&b, "%spartition \"%s\" {\n", strings.Repeat("    ", len(open)), escape.Replace(label))
+			open = append(open, group)
+		}
+		from := "(*)"
+		if up := upstream[id]; len(up) > 0 {
+			from = ids[up[0]]
+		}
+		declare(strings.Repeat("    ", len(open)), from, n)
+	}
+	for len(open) > 0 {
+		open = open[:len(open)-1]
+		fmt.Fprintf(&b, "%s}\n", strings.Repeat("    ", len(open)))
+	}
+	downstream, _ := g.adjacency()
+	for _, id := range order {
+		for i, up := range upstream[id] {
+			// the first upstream edge declared the task
+			if i > 0 {
+				fmt.Fprintf(&b, "%s --> %s\n", ids[up], ids[id])
+			}
+		}
+		if len(downstream[id]) == 0 {
+			fmt.Fprintf(&b, "%s --> (*)\n", ids[id])
+		}
+	}
+	b.WriteString("@enduml\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// a task in a DagAdjacency
+type AdjacencyEntry struct {
+	Operator   string   `json:"operator,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	State      string   `json:"state,omitempty"`
+	Upstream   []string `json:"upstream"`
+	Downstream []string `json:"downstream"`
+}
+
+// the direct dependencies of every task, keyed by task id
+type DagAdjacency struct {
+	DagId string                    `json:"dag_id"`
+	Tasks map[string]AdjacencyEntry `json:"tasks"`
+}
+
+// returns g as an adjacency list
+func (g *DagGraph) Adjacency() DagAdjacency {
+	downstream, upstream := g.adjacency()
+	adjacency := DagAdjacency{DagId: g.DagId, Tasks: map[string]AdjacencyEntry{}}
+	for _, n := range g.Nodes {
+		entry := AdjacencyEntry{
+			Operator:   n.Operator,
+			Group:      n.Group,
+			State:      n.State,
+			Upstream:   append([]string{}, upstream[n.TaskId]...),
+			Downstream: append([]string{}, downstream[n.TaskId]...),
+		}
+		sort.Strings(entry.Upstream)
+		sort.Strings(entry.Downstream)
+		adjacency.Tasks[n.TaskId] = entry
+	}
+	return adjacency
+}
+
+// writes g as a json adjacency list
+func WriteAdjacencyJSON(w io.Writer, g *DagGraph) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g.Adjacency())
+}
@@ -0,0 +1,218 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// python's str(timedelta), e.g. "0:00:01.234000" or "1 day, 2:03:04"
+var pythonDurationRegexp = regexp.MustCompile(`^(?:(-?[0-9]+) days?, )?([0-9]+):([0-9]{2}):([0-9]{2})(?:\.([0-9]{1,6}))?$`)
+
+// parses python's str(timedelta) into a time.Duration
+func ParsePythonDuration(s string) (time.Duration, error) {
+	matches := pythonDurationRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return 0, errors.New("unable to parse duration: " + s)
+	}
+	days := 0
+	if matches[1] != "" {
+		days, _ = strconv.Atoi(matches[1])
+	}
+	hours, _ := strconv.Atoi(matches[2])
+	minutes, _ := strconv.Atoi(matches[3])
+	seconds, _ := strconv.Atoi(matches[4])
+	micros := 0
+	if matches[5] != "" {
+		micros, _ = strconv.Atoi(matches[5] + strings.Repeat("0", 6-len(matches[5])))
+	}
+	return time.Duration(days)*24*time.Hour +
+		time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(micros)*time.Microsecond, nil
+}
+
+// formats d as python's str(timedelta)
+func FormatPythonDuration(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	s := fmt.Sprintf("%d:%02d:%02d", d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second)
+	if micros := d % time.Second / time.Microsecond; micros > 0 {
+		s += fmt.Sprintf(".%06d", micros)
+	}
+	if days == 1 {
+		return "1 day, " + s
+	} else if days != 0 {
+		return fmt.Sprintf("%d days, %s", days, s)
+	}
+	return s
+}
+
+// ints may be json numbers or strings, depending on the airflow version
+func unmarshalLooseInt(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.Atoi(s)
+	}
+	var n int
+	err := json.Unmarshal(raw, &n)
+	return n, err
+}
+
+// lists may be json arrays or python list reprs, e.g. "['a', 'b']"
+func unmarshalLooseStrings(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	s = strings.Trim(strings.TrimSpace(s), "[]")
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `'"`); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func (e *DagReportEntry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		File     string          `json:"file"`
+		Duration json.RawMessage `json:"duration"`
+		DagNum   json.RawMessage `json:"dag_num"`
+		TaskNum  json.RawMessage `json:"task_num"`
+		Dags     json.RawMessage `json:"dags"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	e.File = raw.File
+	var duration string
+	if err := json.Unmarshal(raw.Duration, &duration); err == nil {
+		if e.Duration, err = ParsePythonDuration(duration); err != nil {
+			return err
+		}
+	} else if len(raw.Duration) > 0 && string(raw.Duration) != "null" {
+		// seconds, as a number
+		var seconds float64
+		if err := json.Unmarshal(raw.Duration, &seconds); err != nil {
+			return err
+		}
+		e.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	if e.DagNum, err = unmarshalLooseInt(raw.DagNum); err != nil {
+		return err
+	}
+	if e.TaskNum, err = unmarshalLooseInt(raw.TaskNum); err != nil {
+		return err
+	}
+	e.Dags, err = unmarshalLooseStrings(raw.Dags)
+	return err
+}
+
+// marshals the duration the way airflow prints it, so reports round trip
+func (e DagReportEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File     string   `json:"file,omitempty"`
+		Duration string   `json:"duration,omitempty"`
+		DagNum   int      `json:"dag_num"`
+		TaskNum  int      `json:"task_num"`
+		Dags     []string `json:"dags,omitempty"`
+	}{e.File, FormatPythonDuration(e.Duration), e.DagNum, e.TaskNum, e.Dags})
+}
+
+// time taken to parse every file in the report
+func (r DagReport) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, e := range r {
+		total += e.Duration
+	}
+	return total
+}
+
+// returns the n slowest parsing files, slowest first; n <= 0 returns every file
+func (r DagReport) Slowest(n int) DagReport {
+	sorted := append(DagReport{}, r...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	if n > 0 && n < len(sorted) {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// returns the files taking longer than threshold to parse, slowest first
+func (r DagReport) OverThreshold(threshold time.Duration) DagReport {
+	var over DagReport
+	for _, e := range r.Slowest(0) {
+		if e.Duration > threshold {
+			over = append(over, e)
+		}
+	}
+	return over
+}
+
+// a file whose parse time grew between two reports
+type ParseRegression struct {
+	File   string        `json:"file"`
+	Before time.Duration `json:"before"`
+	After  time.Duration `json:"after"`
+	Delta  time.Duration `json:"delta"`
+	// After / Before, 0 for files new in the later report
+	Ratio float64 `json:"ratio"`
+	New   bool    `json:"new"`
+}
+
+// what counts as a regression, a file must exceed both bounds
+type RegressionOptions struct {
+	// smallest absolute increase flagged, e.g. 500ms
+	MinDelta time.Duration
+	// smallest relative increase flagged, e.g. 1.5 for 50% slower; ignored for new files
+	MinRatio float64
+}
+
+/*
+CompareReports finds files that got slower to parse between two reports, e.g. before and after a deploy
+
+@return []ParseRegression - sorted by largest increase first
+*/
+func CompareReports(before DagReport, after DagReport, opts RegressionOptions) []ParseRegression {
+	previous := map[string]time.Duration{}
+	for _, e := range before {
+		previous[e.File] = e.Duration
+	}
+	regressions := []ParseRegression{}
+	for _, e := range after {
+		old, existed := previous[e.File]
+		r := ParseRegression{File: e.File, Before: old, After: e.Duration, Delta: e.Duration - old, New: !existed}
+		if r.Delta <= 0 || r.Delta < opts.MinDelta {
+			continue
+		}
+		if existed && old > 0 {
+			r.Ratio = float64(e.Duration) / float64(old)
+			if r.Ratio < opts.MinRatio {
+				continue
+			}
+		}
+		regressions = append(regressions, r)
+	}
+	sort.SliceStable(regressions, func(i, j int) bool { return regressions[i].Delta > regressions[j].Delta })
+	return regressions
+}
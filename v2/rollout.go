@@ -0,0 +1,193 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/mwaa"
+)
+
+// an environment artifact that is shipped as a versioned S3 object
+type ArtifactKind string
+
+const (
+	ArtifactRequirements  ArtifactKind = "requirements"
+	ArtifactPlugins       ArtifactKind = "plugins"
+	ArtifactStartupScript ArtifactKind = "startup_script"
+)
+
+// a check run against the environment after an update, a non nil error fails the rollout
+type RolloutCheck struct {
+	Name string
+	Run  func(cli *CLIENT) error
+}
+
+// checks run when RolloutInput.Checks is empty
+var DefaultRolloutChecks = []RolloutCheck{
+	{Name: "version", Run: func(cli *CLIENT) error {
+		_, err := cli.GetVersion()
+		return err
+	}},
+	{Name: "providers", Run: func(cli *CLIENT) error {
+		_, err := cli.GetProviders()
+		return err
+	}},
+	{Name: "dags report", Run: func(cli *CLIENT) error {
+		_, err := cli.DagsReport()
+		return err
+	}},
+}
+
+type RolloutInput struct {
+	Kind ArtifactKind
+	// new content of the artifact
+	Body []byte
+	// S3 key to upload to, defaults to the path the environment currently uses for Kind
+	Key string
+	// where the environment's source bucket is reached
+	Store ObjectStore
+	// post update checks, DefaultRolloutChecks plus a check for new import errors when empty
+	Checks []RolloutCheck
+	// leave the new version in place when checks fail
+	NoRollback bool
+	Wait       EnvironmentWaitOptions
+}
+
+// outcome of one RolloutCheck
+type RolloutCheckResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+type RolloutResult struct {
+	Kind            ArtifactKind         `json:"kind"`
+	Bucket          string               `json:"bucket"`
+	Key             string               `json:"key"`
+	PreviousKey     string               `json:"previous_key,omitempty"`
+	PreviousVersion string               `json:"previous_version,omitempty"`
+	NewVersion      string               `json:"new_version,omitempty"`
+	Checks          []RolloutCheckResult `json:"checks,omitempty"`
+	RolledBack      bool                 `json:"rolled_back"`
+}
+
+// returns the path and object version the environment uses for kind
+func artifactOf(env *mwaa.Environment, kind ArtifactKind) (string, string, error) {
+	switch kind {
+	case ArtifactRequirements:
+		return aws.StringValue(env.RequirementsS3Path), aws.StringValue(env.RequirementsS3ObjectVersion), nil
+	case ArtifactPlugins:
+		return aws.StringValue(env.PluginsS3Path), aws.StringValue(env.PluginsS3ObjectVersion), nil
+	case ArtifactStartupScript:
+		return aws.StringValue(env.StartupScriptS3Path), aws.StringValue(env.StartupScriptS3ObjectVersion), nil
+	}
+	return "", "", fmt.Errorf("unknown artifact kind '%s'", kind)
+}
+
+// returns an UpdateEnvironmentInput pointing kind at key and versionId
+func artifactUpdate(kind ArtifactKind, key string, versionId string) mwaa.UpdateEnvironmentInput {
+	input := mwaa.UpdateEnvironmentInput{}
+	switch kind {
+	case ArtifactRequirements:
+		input.SetRequirementsS3Path(key)
+		input.SetRequirementsS3ObjectVersion(versionId)
+	case ArtifactPlugins:
+		input.SetPluginsS3Path(key)
+		input.SetPluginsS3ObjectVersion(versionId)
+	case ArtifactStartupScript:
+		input.SetStartupScriptS3Path(key)
+		input.SetStartupScriptS3ObjectVersion(versionId)
+	}
+	return input
+}
+
+/*
+Rollout uploads a new requirements.txt, plugins.zip or startup script, points the environment at the new object version and verifies it
+
+The environment update is watched to completion, then every check is run.
+An update MWAA fails and rolls back fails the rollout without running the checks.
+If a check fails the environment is pointed back at the previous object version, unless NoRollback is set or there was no previous version.
+
+@return RolloutResult - what was uploaded, the check outcomes and whether the rollout was rolled back
+*/
+func (cli *CLIENT) Rollout(ctx context.Context, input RolloutInput) (RolloutResult, error) {
+	result := RolloutResult{Kind: input.Kind}
+	if input.Store == nil {
+		return result, errors.New("RolloutInput.Store is nil, please provide an ObjectStore")
+	}
+	env, err := cli.GetEnvironment(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Bucket, err = BucketFromArn(aws.StringValue(env.SourceBucketArn))
+	if err != nil {
+		return result, err
+	}
+	result.PreviousKey, result.PreviousVersion, err = artifactOf(env, input.Kind)
+	if err != nil {
+		return result, err
+	}
+	result.Key = input.Key
+	if result.Key == "" {
+		result.Key = result.PreviousKey
+	}
+	if result.Key == "" {
+		return result, fmt.Errorf("environment has no %s path, please provide RolloutInput.Key", input.Kind)
+	}
+	checks := input.Checks
+	if len(checks) == 0 {
+		checks = append([]RolloutCheck{}, DefaultRolloutChecks...)
+		// only import errors introduced by this rollout fail it
+		baseline, err := cli.GetImportErrors()
+		if err == nil {
+			checks = append(checks, importErrorsCheck(baseline))
+		} else if !isUnavailable(err) {
+			return result, err
+		}
+	}
+	result.NewVersion, err = input.Store.PutObject(ctx, result.Bucket, result.Key, input.Body)
+	if err != nil {
+		return result, err
+	}
+	wait := input.Wait
+	wait.Since = time.Now()
+	err = cli.UpdateEnvironment(ctx, artifactUpdate(input.Kind, result.Key, result.NewVersion))
+	if err != nil {
+		return result, err
+	}
+	// MWAA restores the previous version itself when the update fails, WaitForEnvironment reports that as an error
+	if _, err = cli.WaitForEnvironment(ctx, wait); err != nil {
+		return result, fmt.Errorf("%s rollout was not applied: %w", input.Kind, err)
+	}
+	var failed []string
+	for _, check := range checks {
+		checkResult := RolloutCheckResult{Name: check.Name}
+		if err := check.Run(cli); err != nil {
+			checkResult.Error = err.Error()
+			failed = append(failed, check.Name+": "+err.Error())
+		}
+		result.Checks = append(result.Checks, checkResult)
+	}
+	if len(failed) == 0 {
+		return result, nil
+	}
+	checkErr := fmt.Errorf("%s rollout failed checks:\n%s", input.Kind, strings.Join(failed, "\n"))
+	if input.NoRollback || result.PreviousVersion == "" {
+		return result, checkErr
+	}
+	wait.Since = time.Now()
+	err = cli.UpdateEnvironment(ctx, artifactUpdate(input.Kind, result.PreviousKey, result.PreviousVersion))
+	if err != nil {
+		return result, fmt.Errorf("%w\nrollback failed: %s", checkErr, err)
+	}
+	if _, err = cli.WaitForEnvironment(ctx, wait); err != nil {
+		return result, fmt.Errorf("%w\nrollback failed: %s", checkErr, err)
+	}
+	result.RolledBack = true
+	return result, checkErr
+}
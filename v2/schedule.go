@@ -0,0 +1,208 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// upcoming logical dates of a DAG
+type NextExecutions struct {
+	DagId string `json:"dag_id"`
+	// sorted, empty for DAGs without a schedule
+	LogicalDates []time.Time `json:"logical_dates"`
+	// paused DAGs do not run on their schedule until unpaused
+	Paused bool `json:"paused"`
+}
+
+// parses `dags next-execution`, one isoformat date or None per line
+func ParseNextExecutions(data MWAAData) ([]time.Time, error) {
+	dates := []time.Time{}
+	for _, line := range strings.Split(data.StdoutStr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "None" {
+			// the schedule ends, or there is none
+			break
+		}
+		if line == "" || strings.HasPrefix(line, "[") {
+			// blank and log lines
+			continue
+		}
+		t, err := parseAirflowTime(line)
+		if err != nil {
+			return []time.Time{}, err
+		}
+		dates = append(dates, t)
+	}
+	return dates, nil
+}
+
+/*
+GetNextExecutions returns the next logical dates of a DAG
+
+A scheduled run starts once its data interval ends, for cron and timedelta schedules that is at the next logical date.
+
+@param num int - how many logical dates, more than 1 needs airflow 2.1+
+*/
+func (cli *CLIENT) GetNextExecutions(dagId string, num int) (NextExecutions, error) {
+	// airflow dags next-execution [-h] [-n NUM_EXECUTIONS] [-S SUBDIR] dag_id
+	next := NextExecutions{DagId: dagId, LogicalDates: []time.Time{}}
+	cmd := `dags next-execution`
+	if num > 1 {
+		cmd += fmt.Sprintf(` --num-executions %d`, num)
+	}
+	cmd += fmt.Sprintf(` '%s'`, dagId)
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return next, err
+	}
+	next.Paused = strings.Contains(data.StderrStr+data.StdoutStr, "DAG is PAUSED")
+	next.LogicalDates, err = ParseNextExecutions(data)
+	return next, err
+}
+
+// args for ForecastRuns
+type ForecastInput struct {
+	DagIds []string
+	// forecast the DAGs matching Selector in addition to DagIds
+	Selector *DagQuery
+	// window of run start times, End excluded
+	Start time.Time
+	End   time.Time
+	// logical dates asked for per DAG, defaults to 100; DAGs running more often than this in the window are cut short
+	MaxRunsPerDag int
+	// leave out paused DAGs
+	SkipPaused bool
+}
+
+// a scheduled run expected in a forecast window
+type ForecastRun struct {
+	DagId       string    `json:"dag_id"`
+	LogicalDate time.Time `json:"logical_date"`
+	// when the run is expected to start, the next logical date
+	StartsAt time.Time `json:"starts_at"`
+	Paused   bool      `json:"paused,omitempty"`
+}
+
+// a span of time
+type TimeWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type Forecast struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// sorted by start time, then dag id
+	Runs []ForecastRun `json:"runs"`
+}
+
+// returns the runs of dagId expected to start in [start, end)
+func forecastDag(next NextExecutions, start time.Time, end time.Time) []ForecastRun {
+	runs := []ForecastRun{}
+	dates := next.LogicalDates
+	for i := 0; i+1 < len(dates); i++ {
+		startsAt := dates[i+1]
+		if startsAt.Before(start) || !startsAt.Before(end) {
+			continue
+		}
+		runs = append(runs, ForecastRun{DagId: next.DagId, LogicalDate: dates[i], StartsAt: startsAt, Paused: next.Paused})
+	}
+	return runs
+}
+
+/*
+ForecastRuns builds a timeline of the scheduled runs of many DAGs over a window
+
+Runs are forecast from `dags next-execution`, so the window is expected to start around now; manual and dataset triggered runs are not forecast.
+A run starts at the logical date after its own, so the forecast needs several dates per DAG and airflow 2.1+, where `dags next-execution` takes --num-executions;
+on 2.0 an error is returned before anything is sent, GetNextExecutions with num 1 still gives the next logical date.
+
+@return Forecast - the expected runs sorted by start time
+*/
+func (cli *CLIENT) ForecastRuns(input ForecastInput) (Forecast, error) {
+	forecast := Forecast{Start: input.Start, End: input.End, Runs: []ForecastRun{}}
+	if !input.Start.Before(input.End) {
+		return forecast, errors.New("ForecastInput.Start must be before End")
+	}
+	if err := cli.Supports("dags next-execution", "--num-executions"); err != nil {
+		return forecast, fmt.Errorf("ForecastRuns needs the dates after each logical date: %w", err)
+	}
+	num := input.MaxRunsPerDag
+	if num <= 0 {
+		num = 100
+	}
+	dagIds := map[string]bool{}
+	for _, dagId := range input.DagIds {
+		dagIds[dagId] = true
+	}
+	if input.Selector != nil {
+		dags, err := cli.QueryDags(*input.Selector)
+		if err != nil {
+			return forecast, err
+		}
+		for _, d := range dags {
+			dagIds[d.DagId] = true
+		}
+	}
+	for dagId := range dagIds {
+		// one more logical date than runs, the last run starts at the date after it
+		next, err := cli.GetNextExecutions(dagId, num+1)
+		if err != nil {
+			return forecast, fmt.Errorf("%s: %w", dagId, err)
+		}
+		if next.Paused && input.SkipPaused {
+			continue
+		}
+		forecast.Runs = append(forecast.Runs, forecastDag(next, input.Start, input.End)...)
+	}
+	sortForecastRuns(forecast.Runs)
+	return forecast, nil
+}
+
+func sortForecastRuns(runs []ForecastRun) {
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].StartsAt.Equal(runs[j].StartsAt) {
+			return runs[i].StartsAt.Before(runs[j].StartsAt)
+		}
+		return runs[i].DagId < runs[j].DagId
+	})
+}
+
+// returns the runs starting within w, or within margin of it
+func (f Forecast) Collisions(w TimeWindow, margin time.Duration) []ForecastRun {
+	runs := []ForecastRun{}
+	for _, run := range f.Runs {
+		if !run.StartsAt.Before(w.Start.Add(-margin)) && run.StartsAt.Before(w.End.Add(margin)) {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+/*
+FreeWindows returns the spans of the forecast window in which no run starts, at least minLength long
+
+@param margin time.Duration - kept clear around every run start, e.g. the time runs usually take
+*/
+func (f Forecast) FreeWindows(minLength time.Duration, margin time.Duration) []TimeWindow {
+	windows := []TimeWindow{}
+	free := f.Start
+	add := func(end time.Time) {
+		if end.Sub(free) >= minLength && end.After(free) {
+			windows = append(windows, TimeWindow{Start: free, End: end})
+		}
+	}
+	for _, run := range f.Runs {
+		add(run.StartsAt.Add(-margin))
+		if busy := run.StartsAt.Add(margin); busy.After(free) {
+			free = busy
+		}
+	}
+	add(f.End)
+	return windows
+}
@@ -0,0 +1,412 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// returned by NewDagRun, wrapped, when Conf does not match the schema registered for the DAG
+var ErrInvalidConf = errors.New("invalid dag run conf")
+
+/*
+JSONSchema is the subset of JSON Schema airflow params use
+
+Supported keywords: type, enum, const, properties, required, additionalProperties, items,
+minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems, maxItems, allOf, anyOf and oneOf.
+Other keywords are ignored.
+*/
+type JSONSchema struct {
+	// empty allows any type
+	Type       []string
+	Enum       []interface{}
+	Const      interface{}
+	HasConst   bool
+	Properties map[string]*JSONSchema
+	Required   []string
+	// false forbids properties not in Properties
+	AdditionalPropertiesAllowed bool
+	// schema of properties not in Properties, nil allows anything
+	AdditionalProperties *JSONSchema
+	Items                *JSONSchema
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	MinItems             *int
+	MaxItems             *int
+	AllOf                []*JSONSchema
+	AnyOf                []*JSONSchema
+	OneOf                []*JSONSchema
+}
+
+// a value that does not match its schema
+type SchemaViolation struct {
+	// json path of the value, e.g. $.tables[0].name
+	Path    string
+	Message string
+}
+
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type                 json.RawMessage        `json:"type"`
+		Enum                 []interface{}          `json:"enum"`
+		Const                json.RawMessage        `json:"const"`
+		Properties           map[string]*JSONSchema `json:"properties"`
+		Required             []string               `json:"required"`
+		AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+		Items                *JSONSchema            `json:"items"`
+		Minimum              *float64               `json:"minimum"`
+		Maximum              *float64               `json:"maximum"`
+		ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+		ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+		MinLength            *int                   `json:"minLength"`
+		MaxLength            *int                   `json:"maxLength"`
+		Pattern              *string                `json:"pattern"`
+		MinItems             *int                   `json:"minItems"`
+		MaxItems             *int                   `json:"maxItems"`
+		AllOf                []*JSONSchema          `json:"allOf"`
+		AnyOf                []*JSONSchema          `json:"anyOf"`
+		OneOf                []*JSONSchema          `json:"oneOf"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = JSONSchema{
+		Enum:                        raw.Enum,
+		Properties:                  raw.Properties,
+		Required:                    raw.Required,
+		AdditionalPropertiesAllowed: true,
+		Items:                       raw.Items,
+		Minimum:                     raw.Minimum,
+		Maximum:                     raw.Maximum,
+		ExclusiveMinimum:            raw.ExclusiveMinimum,
+		ExclusiveMaximum:            raw.ExclusiveMaximum,
+		MinLength:                   raw.MinLength,
+		MaxLength:                   raw.MaxLength,
+		MinItems:                    raw.MinItems,
+		MaxItems:                    raw.MaxItems,
+		AllOf:                       raw.AllOf,
+		AnyOf:                       raw.AnyOf,
+		OneOf:                       raw.OneOf,
+	}
+	if len(raw.Type) > 0 {
+		var one string
+		if err := json.Unmarshal(raw.Type, &one); err == nil {
+			s.Type = []string{one}
+		} else if err := json.Unmarshal(raw.Type, &s.Type); err != nil {
+			return errors.New("schema type must be a string or a list of strings")
+		}
+	}
+	if len(raw.Const) > 0 {
+		s.HasConst = true
+		if err := json.Unmarshal(raw.Const, &s.Const); err != nil {
+			return err
+		}
+	}
+	if len(raw.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(raw.AdditionalProperties, &allowed); err == nil {
+			s.AdditionalPropertiesAllowed = allowed
+		} else if err := json.Unmarshal(raw.AdditionalProperties, &s.AdditionalProperties); err != nil {
+			return fmt.Errorf("schema additionalProperties: %w", err)
+		}
+	}
+	if raw.Pattern != nil {
+		pattern, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema pattern: %w", err)
+		}
+		s.Pattern = pattern
+	}
+	return nil
+}
+
+// parses a JSON Schema document
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	var schema JSONSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid json schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// returns the JSON Schema type of a value decoded from json
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *JSONSchema) allowsType(v interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	t := jsonType(v)
+	for _, allowed := range s.Type {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// appends the violations of v, found at path, to violations
+func (s *JSONSchema) validate(v interface{}, path string, violations []SchemaViolation) []SchemaViolation {
+	violate := func(format string, a ...interface{}) {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, a...)})
+	}
+	if !s.allowsType(v) {
+		violate("is %s, want %s", jsonType(v), strings.Join(s.Type, " or "))
+		// the other keywords would only repeat the type mismatch
+		return violations
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violate("%v is not one of %v", v, s.Enum)
+		}
+	}
+	if s.HasConst && !reflect.DeepEqual(s.Const, v) {
+		violate("%v is not %v", v, s.Const)
+	}
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			violate("%v is less than the minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			violate("%v is greater than the maximum %v", v, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			violate("%v is not greater than %v", v, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			violate("%v is not less than %v", v, *s.ExclusiveMaximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			violate("is %d characters long, shorter than %d", length, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			violate("is %d characters long, longer than %d", length, *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			violate("%q does not match %s", v, s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			violate("has %d items, fewer than %d", len(v), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			violate("has %d items, more than %d", len(v), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				violations = s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, SchemaViolation{Path: path + "." + name, Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propertyPath := path + "." + name
+			if property, ok := s.Properties[name]; ok {
+				violations = property.validate(v[name], propertyPath, violations)
+			} else if !s.AdditionalPropertiesAllowed {
+				violations = append(violations, SchemaViolation{Path: propertyPath, Message: "is not an allowed property"})
+			} else if s.AdditionalProperties != nil {
+				violations = s.AdditionalProperties.validate(v[name], propertyPath, violations)
+			}
+		}
+	}
+	for _, sub := range s.AllOf {
+		violations = sub.validate(v, path, violations)
+	}
+	if len(s.AnyOf) > 0 && s.matching(s.AnyOf, v) == 0 {
+		violate("matches none of anyOf")
+	}
+	if len(s.OneOf) > 0 {
+		if n := s.matching(s.OneOf, v); n != 1 {
+			violate("matches %d of oneOf, want exactly 1", n)
+		}
+	}
+	return violations
+}
+
+// returns how many of schemas v matches
+func (s *JSONSchema) matching(schemas []*JSONSchema, v interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(sub.validate(v, "$", nil)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+Validate checks a value against the schema
+
+The value is round tripped through json first, so Go numbers, structs and maps validate like the json airflow receives.
+
+@return []SchemaViolation - every violation found, empty when the value is valid
+*/
+func (s *JSONSchema) Validate(value interface{}) ([]SchemaViolation, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return s.validate(v, "$", []SchemaViolation{}), nil
+}
+
+// returns the schema of a DAG's params, as printed by `dags details --output json`
+func paramsSchema(params map[string]json.RawMessage) *JSONSchema {
+	schema := &JSONSchema{Type: []string{"object"}, Properties: map[string]*JSONSchema{}, AdditionalPropertiesAllowed: true}
+	for name, raw := range params {
+		var param struct {
+			Value  json.RawMessage `json:"value"`
+			Schema *JSONSchema     `json:"schema"`
+		}
+		if err := json.Unmarshal(raw, &param); err != nil {
+			// a plain default value rather than a serialized Param
+			param.Value = raw
+		}
+		property := param.Schema
+		if property == nil {
+			property = &JSONSchema{AdditionalPropertiesAllowed: true}
+		}
+		schema.Properties[name] = property
+		// a param without a default must be passed, unless its schema accepts null
+		if (len(param.Value) == 0 || string(param.Value) == "null") && !property.allowsType(nil) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// sets the schema NewDagRun validates the Conf of dagId against, a nil schema removes it
+func (cli *CLIENT) RegisterConfSchema(dagId string, schema *JSONSchema) {
+	cli.schemaMu.Lock()
+	defer cli.schemaMu.Unlock()
+	if schema == nil {
+		delete(cli.confSchemas, dagId)
+		return
+	}
+	if cli.confSchemas == nil {
+		cli.confSchemas = map[string]*JSONSchema{}
+	}
+	cli.confSchemas[dagId] = schema
+}
+
+// registers the JSON Schema in file path for dagId
+func (cli *CLIENT) RegisterConfSchemaFile(dagId string, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	schema, err := ParseJSONSchema(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	cli.RegisterConfSchema(dagId, schema)
+	return nil
+}
+
+/*
+RegisterConfSchemaFromParams registers a schema built from the params of dagId
+
+Params come from `dags details`, airflow 2.8.1+. Params without a default are required, conf keys that are not params are allowed.
+*/
+func (cli *CLIENT) RegisterConfSchemaFromParams(dagId string) error {
+	data, err := PostMWAACommand(cli, fmt.Sprintf(`dags details '%s' --output json`, dagId))
+	if err != nil {
+		return err
+	}
+	var details []struct {
+		Params map[string]json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data.Stdout, &details); err != nil || len(details) != 1 {
+		return errors.New("unable to parse dags details of " + dagId)
+	}
+	cli.RegisterConfSchema(dagId, paramsSchema(details[0].Params))
+	return nil
+}
+
+// returns the schema registered for dagId
+func (cli *CLIENT) ConfSchema(dagId string) (*JSONSchema, bool) {
+	cli.schemaMu.Lock()
+	defer cli.schemaMu.Unlock()
+	schema, ok := cli.confSchemas[dagId]
+	return schema, ok
+}
+
+// validates conf against the schema registered for dagId, if any
+func (cli *CLIENT) validateConf(dagId string, conf map[string]interface{}) error {
+	schema, ok := cli.ConfSchema(dagId)
+	if !ok {
+		return nil
+	}
+	if conf == nil {
+		conf = map[string]interface{}{}
+	}
+	violations, err := schema.Validate(conf)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = v.Path + " " + v.Message
+	}
+	return fmt.Errorf("%w for %s:\n%s", ErrInvalidConf, dagId, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// returned when a command, flag or argument is not available in the environment's airflow version
+var ErrUnsupportedByVersion = errors.New("unsupported by airflow version")
+
+// the airflow versions in which a command or flag is available
+// Since is inclusive, Until is exclusive; a zero Until means there is no upper bound
+type VersionRange struct {
+	Since Version
+	Until Version
+}
+
+// true if v falls within the range
+func (r VersionRange) Contains(v Version) bool {
+	if !v.AtLeast(r.Since) {
+		return false
+	}
+	return r.Until.IsZero() || v.Compare(r.Until) < 0
+}
+
+// availability of a cli command and of its version specific flags
+// Flags are keyed by long flag name, e.g. "--columns"; positional arguments added in a version are keyed as "<name>",
+// e.g. "<run_id>", and positional arguments that became optional in a version as "[name]", e.g. "[execution_date]"
+type CommandSupport struct {
+	VersionRange
+	Flags map[string]VersionRange
+}
+
+// the capability matrix: which airflow versions support each command and flag mwaah uses
+// commands missing from the matrix are not checked
+var SupportMatrix = map[string]CommandSupport{
+	"version":              {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"connections add":      {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"connections delete":   {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags delete":          {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags list-jobs":       {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags list-runs":       {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags pause":           {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags unpause":         {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags report":          {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags show":            {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags state":           {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags trigger":         {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags reserialize":     {VersionRange: VersionRange{Since: ver(2, 4, 0)}},
+	"dags details":         {VersionRange: VersionRange{Since: ver(2, 8, 1)}},
+	"config get-value":     {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"db clean":             {VersionRange: VersionRange{Since: ver(2, 3, 0)}},
+	"providers behaviours": {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"providers get":        {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"providers hooks":      {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"providers links":      {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"providers list":       {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"roles list":           {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"tasks clear":          {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"tasks list":           {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"variables delete":     {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"variables get":        {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"variables list":       {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"variables set":        {VersionRange: VersionRange{Since: ver(2, 0, 0)}},
+	"dags list": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"--columns": {Since: ver(2, 10, 0)},
+		},
+	},
+	"dags list-import-errors": {VersionRange: VersionRange{Since: ver(2, 1, 0)}},
+	"dags backfill": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"--continue-on-failures": {Since: ver(2, 3, 0)},
+		},
+	},
+	"dags next-execution": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"--num-executions": {Since: ver(2, 1, 0)},
+		},
+	},
+	"dags test": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"--conf": {Since: ver(2, 5, 0)},
+			// execution_date became optional, defaulting to now
+			"[execution_date]": {Since: ver(2, 5, 0)},
+		},
+	},
+	"tasks failed-deps": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"<run_id>": {Since: ver(2, 2, 0)},
+		},
+	},
+	"tasks state": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"<run_id>": {Since: ver(2, 2, 0)},
+		},
+	},
+	"tasks states-for-dag-run": {
+		VersionRange: VersionRange{Since: ver(2, 0, 0)},
+		Flags: map[string]VersionRange{
+			"<run_id>": {Since: ver(2, 2, 0)},
+		},
+	},
+}
+
+// returns nil if v supports command and every one of flags, otherwise an error wrapping ErrUnsupportedByVersion
+func CheckSupport(v Version, command string, flags ...string) error {
+	support, ok := SupportMatrix[command]
+	if !ok {
+		return nil
+	}
+	if !support.Contains(v) {
+		return fmt.Errorf("%w: `%s` requires airflow %s, environment runs %s", ErrUnsupportedByVersion, command, support.Since, v)
+	}
+	for _, flag := range flags {
+		r, ok := support.Flags[flag]
+		if ok && !r.Contains(v) {
+			return fmt.Errorf("%w: `%s %s` requires airflow %s, environment runs %s", ErrUnsupportedByVersion, command, flag, r.Since, v)
+		}
+	}
+	return nil
+}
+
+// returns nil if the environment's airflow version supports command and flags
+func (cli *CLIENT) Supports(command string, flags ...string) error {
+	v, err := cli.AirflowVersion()
+	if err != nil {
+		return err
+	}
+	return CheckSupport(v, command, flags...)
+}
+
+// true if the environment's airflow version supports flag on command, used to pick version appropriate flags
+func (cli *CLIENT) SupportsFlag(command string, flag string) bool {
+	return cli.Supports(command, flag) == nil
+}
+
+// a cli command string split into the command path and the flags it uses
+type parsedCommand struct {
+	Command string
+	Flags   []string
+	Args    []string
+}
+
+// splits a cmd string as sent to the airflow entrypoint, honoring single quotes
+func parseCommand(cmd string) parsedCommand {
+	type token struct {
+		val    string
+		quoted bool
+	}
+	var tokens []token
+	var cur strings.Builder
+	inQuote, quoted, started := false, false, false
+	for _, r := range cmd {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+			quoted, started = true, true
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuote:
+			if started {
+				tokens = append(tokens, token{cur.String(), quoted})
+			}
+			cur.Reset()
+			quoted, started = false, false
+		default:
+			cur.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		tokens = append(tokens, token{cur.String(), quoted})
+	}
+	var p parsedCommand
+	var words []string
+	for _, t := range tokens {
+		if !t.quoted && strings.HasPrefix(t.val, "-") {
+			p.Flags = append(p.Flags, strings.SplitN(t.val, "=", 2)[0])
+			continue
+		}
+		words = append(words, t.val)
+	}
+	// command paths are one or two words deep, e.g. `version` or `dags list`
+	if len(words) > 1 {
+		if _, ok := SupportMatrix[words[0]+" "+words[1]]; ok || isCommandGroup(words[0]) {
+			p.Command = words[0] + " " + words[1]
+			p.Args = words[2:]
+			return p
+		}
+	}
+	if len(words) > 0 {
+		p.Command = words[0]
+		p.Args = words[1:]
+	}
+	return p
+}
+
+// true if name is a top level command that takes a subcommand
+func isCommandGroup(name string) bool {
+	for command := range SupportMatrix {
+		if strings.HasPrefix(command, name+" ") {
+			return true
+		}
+	}
+	return false
+}
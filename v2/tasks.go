@@ -58,21 +58,13 @@ func UnmarshalTasks(data MWAAData) ([]Task, error) {
 	return tasks, nil
 }
 
+// returns every task of `tasks list` or `tasks list --tree` output once, in the order it first appears
 func UnmarshalDagTasks(data MWAAData) ([]DagTask, error) {
-	var tasks []DagTask
-	splitLines := strings.Split(data.StdoutStr, "\n")
-	for _, line := range splitLines {
-		replacer := strings.NewReplacer("<", "", ">", "", "(", "", ")", "")
-		vals := strings.Split(replacer.Replace(line), ": ")
-		operator := airflow.NewNullableString(&vals[0])
-		taskId := vals[1]
-		task := DagTask{
-			Operator: *operator,
-			TaskId:   &taskId,
-		}
-		tasks = append(tasks, task)
+	tree, err := ParseTaskTree(data.StdoutStr)
+	if err != nil {
+		return []DagTask{}, err
 	}
-	return tasks, nil
+	return tree.Tasks(), nil
 }
 
 func UnmarshalTaskStatesDetailed(data MWAAData) ([]TaskStatesDetailed, error) {
@@ -228,6 +220,9 @@ func (cli *CLIENT) GetTaskFailedDeps(dagId string, taskId string, executionDate
 	if executionDate.IsSet() {
 		cmd += fmt.Sprintf(` '%s'`, executionDate.Get())
 	} else {
+		if err := cli.Supports(`tasks failed-deps`, `<run_id>`); err != nil {
+			return MWAAData{}, err
+		}
 		cmd += fmt.Sprintf(` '%s'`, *runId.Get())
 	}
 	return PostMWAACommand(cli, cmd)
@@ -263,6 +258,9 @@ func (cli *CLIENT) GetTaskState(dagId string, taskId string, executionDate airfl
 	if executionDate.IsSet() {
 		cmd += fmt.Sprintf(` '%s'`, executionDate.Get())
 	} else {
+		if err := cli.Supports(`tasks state`, `<run_id>`); err != nil {
+			return airflow.DagState(""), err
+		}
 		cmd += fmt.Sprintf(` '%s'`, *runId.Get())
 	}
 	data, err := PostMWAACommand(cli, cmd)
@@ -286,6 +284,9 @@ func (cli *CLIENT) GetTaskStatesDetailed(dagId string, executionDate airflow.Nul
 	if executionDate.IsSet() {
 		cmd += fmt.Sprintf(` '%s'`, executionDate.Get())
 	} else {
+		if err := cli.Supports(`tasks states-for-dag-run`, `<run_id>`); err != nil {
+			return []TaskStatesDetailed{}, err
+		}
 		cmd += fmt.Sprintf(` '%s'`, *runId.Get())
 	}
 	data, err := PostMWAACommand(cli, cmd)
@@ -0,0 +1,179 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// a task as printed by `tasks list --tree`, e.g. "<Task(BashOperator): print_date>" or "<Mapped(PythonOperator): add>"
+var taskReprRegexp = regexp.MustCompile(`^<(Task|Mapped)\(([^)]*)\): (.+)>$`)
+
+// a task id as printed by `tasks list`
+var taskIdRegexp = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// ErrStopWalk may be returned by a TaskTree.Walk callback to stop the walk without failing it
+var ErrStopWalk = errors.New("stop walk")
+
+// a task in a TaskTree
+type TaskTreeNode struct {
+	TaskId string `json:"task_id"`
+	// operator class name, empty for the flat `tasks list` output
+	Operator string `json:"operator,omitempty"`
+	// a dynamically mapped task
+	Mapped bool `json:"mapped,omitempty"`
+	// 0 for the roots
+	Depth int `json:"depth"`
+	// the task already appeared earlier in the tree, airflow prints a task again under each of its upstream tasks
+	Repeated bool            `json:"repeated,omitempty"`
+	Children []*TaskTreeNode `json:"children,omitempty"`
+}
+
+// the tasks of a DAG as printed by `tasks list --tree`, each task nested under its upstream tasks
+type TaskTree struct {
+	Roots []*TaskTreeNode `json:"roots"`
+}
+
+// returns the width of the leading whitespace of line, tabs counting as 4
+func indentWidth(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+/*
+ParseTaskTree parses the output of `tasks list --tree`, or of plain `tasks list` which lists one task id per line
+
+Lines that are neither, e.g. log lines, are ignored.
+
+@return *TaskTree
+*/
+func ParseTaskTree(out string) (*TaskTree, error) {
+	tree := &TaskTree{Roots: []*TaskTreeNode{}}
+	// innermost last
+	var stack []*TaskTreeNode
+	var indents []int
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		text := strings.TrimSpace(line)
+		if text == "" {
+			continue
+		}
+		node := &TaskTreeNode{}
+		if m := taskReprRegexp.FindStringSubmatch(text); m != nil {
+			node.Mapped = m[1] == "Mapped"
+			node.Operator = m[2]
+			node.TaskId = m[3]
+		} else if taskIdRegexp.MatchString(text) {
+			node.TaskId = text
+		} else {
+			continue
+		}
+		indent := indentWidth(line)
+		for len(indents) > 0 && indents[len(indents)-1] >= indent {
+			stack = stack[:len(stack)-1]
+			indents = indents[:len(indents)-1]
+		}
+		node.Depth = len(stack)
+		node.Repeated = seen[node.TaskId]
+		seen[node.TaskId] = true
+		if len(stack) == 0 {
+			tree.Roots = append(tree.Roots, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+		indents = append(indents, indent)
+	}
+	return tree, nil
+}
+
+// calls fn for every node depth first, parents before their children; stops at the first error, ErrStopWalk stops without an error
+func (t *TaskTree) Walk(fn func(n *TaskTreeNode) error) error {
+	var walk func(nodes []*TaskTreeNode) error
+	walk = func(nodes []*TaskTreeNode) error {
+		for _, n := range nodes {
+			if err := fn(n); err != nil {
+				return err
+			}
+			if err := walk(n.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(t.Roots)
+	if errors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// returns every task once, in the order it first appears
+func (t *TaskTree) Tasks() []DagTask {
+	tasks := []DagTask{}
+	t.Walk(func(n *TaskTreeNode) error {
+		if !n.Repeated {
+			taskId, operator := n.TaskId, n.Operator
+			task := DagTask{TaskId: &taskId}
+			if operator != "" {
+				task.Operator.Set(&operator)
+			}
+			tasks = append(tasks, task)
+		}
+		return nil
+	})
+	return tasks
+}
+
+// converts the tree to the graph model, each parent becoming upstream of its children
+func (t *TaskTree) ToGraph() *DagGraph {
+	g := &DagGraph{Nodes: []DagGraphNode{}, Edges: []DagEdge{}, Groups: []DagGroup{}}
+	edges := map[DagEdge]bool{}
+	var add func(parent *TaskTreeNode, nodes []*TaskTreeNode)
+	add = func(parent *TaskTreeNode, nodes []*TaskTreeNode) {
+		for _, n := range nodes {
+			if !n.Repeated {
+				g.Nodes = append(g.Nodes, DagGraphNode{TaskId: n.TaskId, Label: n.TaskId, Operator: n.Operator})
+			}
+			if parent != nil {
+				e := DagEdge{From: parent.TaskId, To: n.TaskId}
+				if !edges[e] {
+					edges[e] = true
+					g.Edges = append(g.Edges, e)
+				}
+			}
+			add(n, n.Children)
+		}
+	}
+	add(nil, t.Roots)
+	return g
+}
+
+// returns the task tree of a DAG
+func (cli *CLIENT) GetTaskTree(dagId string) (*TaskTree, error) {
+	// airflow tasks list [-h] [-S SUBDIR] [-t] [-v] dag_id
+	cmd := fmt.Sprintf(`tasks list '%s' --tree`, dagId)
+	data, err := PostMWAACommand(cli, cmd)
+	if err != nil {
+		return nil, err
+	}
+	dagIdNotFoundException := fmt.Sprintf(`airflow.exceptions.AirflowException: Dag '%s' could not be found; either it does not exist or it failed to parse.`, dagId)
+	if strings.Contains(data.StderrStr, dagIdNotFoundException) {
+		return nil, errors.New(dagIdNotFoundException)
+	}
+	return ParseTaskTree(data.StdoutStr)
+}
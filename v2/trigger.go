@@ -0,0 +1,123 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// returned by NewDagRun, wrapped, when the DAG already has a run with the run id or logical date
+var ErrDagRunExists = errors.New("dag run already exists")
+
+// the ways airflow reports a duplicate dag run across versions
+var dagRunConflictRegexp = regexp.MustCompile(`(?i)(DagRunAlreadyExists|already exists for dag|duplicate key value violates unique constraint "dag_run_|UNIQUE constraint failed: dag_run\.)`)
+
+// tells whether stderr of `dags trigger` reports a duplicate dag run
+func isDagRunConflict(stderr string) bool {
+	return dagRunConflictRegexp.MatchString(stderr)
+}
+
+// args for TriggerDagRunOnce
+type TriggerOnceInput struct {
+	// required
+	DagId string
+	// required, the run id depends on it so it cannot default to now
+	LogicalDate time.Time
+	Conf        map[string]interface{}
+	// identifies the run instead of Conf, e.g. a request id of the caller
+	Key string
+}
+
+/*
+IdempotentRunId returns the run id TriggerDagRunOnce uses for input
+
+The run id is manual__<logical date>_<hash>, the hash covering the dag id, the logical date and Key, or Conf when Key is empty.
+Conf is hashed as json, whose map keys are sorted, so equal confs give the same run id.
+*/
+func IdempotentRunId(input TriggerOnceInput) (string, error) {
+	if input.DagId == "" {
+		return "", errors.New("TriggerOnceInput.DagId is empty, please provide a DagId")
+	}
+	if input.LogicalDate.IsZero() {
+		return "", errors.New("TriggerOnceInput.LogicalDate is required")
+	}
+	date := input.LogicalDate.UTC().Format(PythonISONoDecimalTimeLayout)
+	identity := []byte(input.Key)
+	if input.Key == "" {
+		conf, err := json.Marshal(input.Conf)
+		if err != nil {
+			return "", errors.New("error marshaling TriggerOnceInput.Conf")
+		}
+		identity = conf
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", input.DagId, date)
+	h.Write(identity)
+	return fmt.Sprintf("%s__%s_%s", RunTypeManual, date, hex.EncodeToString(h.Sum(nil))[:12]), nil
+}
+
+// returns the run of dagId with runId, triggered for logicalDate
+func (cli *CLIENT) findDagRun(dagId string, runId string, logicalDate time.Time) (airflow.DAGRun, bool, error) {
+	// list-runs filters by day
+	runs, err := cli.ListDagRuns(ListDagRunsInput{DagId: dagId, StartDate: logicalDate, EndDate: logicalDate})
+	if err != nil {
+		return airflow.DAGRun{}, false, err
+	}
+	run, found := GetDagByRunId(runs, runId)
+	return run, found, nil
+}
+
+/*
+TriggerDagRunOnce triggers a DAG with a run id derived from input, so retrying the same trigger never creates a second run
+
+When the run already exists, or airflow reports it as a duplicate, the existing run is returned.
+A run at the same logical date with another run id, e.g. a scheduled run, is not this trigger: ErrDagRunExists is returned, wrapped.
+
+@return airflow.DAGRun - the new or existing run
+@return bool - true when the run was created by this call
+*/
+func (cli *CLIENT) TriggerDagRunOnce(input TriggerOnceInput) (airflow.DAGRun, bool, error) {
+	runId, err := IdempotentRunId(input)
+	if err != nil {
+		return airflow.DAGRun{}, false, err
+	}
+	run, found, err := cli.findDagRun(input.DagId, runId, input.LogicalDate)
+	if err != nil {
+		return airflow.DAGRun{}, false, err
+	}
+	if found {
+		return run, false, nil
+	}
+	dagRun := airflow.NewDAGRun()
+	dagRun.SetDagId(input.DagId)
+	dagRun.SetDagRunId(runId)
+	dagRun.SetExecutionDate(input.LogicalDate)
+	if input.Conf != nil {
+		dagRun.SetConf(input.Conf)
+	}
+	newDagRun, err := cli.NewDagRun(*dagRun)
+	if errors.Is(err, ErrDagRunExists) {
+		// triggered concurrently since the lookup
+		run, found, findErr := cli.findDagRun(input.DagId, runId, input.LogicalDate)
+		if findErr != nil {
+			return airflow.DAGRun{}, false, findErr
+		}
+		if found {
+			return run, false, nil
+		}
+		// another run holds the logical date
+		return airflow.DAGRun{}, false, err
+	}
+	if err != nil {
+		return airflow.DAGRun{}, false, err
+	}
+	return *newDagRun, true, nil
+}
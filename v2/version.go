@@ -2,6 +2,77 @@
 // See the LICENSE file for license information.
 package mwaah
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semantic version of an airflow installation, e.g. 2.2.2
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	// the string the version was parsed from
+	Raw string `json:"-"`
+}
+
+var versionRegexp = regexp.MustCompile(`v?([0-9]+)\.([0-9]+)(?:\.([0-9]+))?`)
+
+// shorthand for Version literals
+func ver(major int, minor int, patch int) Version {
+	return Version{Major: major, Minor: minor, Patch: patch}
+}
+
+// parses the output of `airflow version` (or any string containing a version) into a Version
+// the last line that contains a version wins, as stdout may be preceded by log lines
+func ParseVersion(s string) (Version, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		matches := versionRegexp.FindStringSubmatch(lines[i])
+		if matches == nil {
+			continue
+		}
+		v := Version{Raw: strings.TrimSpace(lines[i])}
+		v.Major, _ = strconv.Atoi(matches[1])
+		v.Minor, _ = strconv.Atoi(matches[2])
+		if matches[3] != "" {
+			v.Patch, _ = strconv.Atoi(matches[3])
+		}
+		return v, nil
+	}
+	return Version{}, errors.New("unable to parse airflow version from: " + s)
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// returns -1, 0 or 1 if v is less than, equal to, or greater than o
+func (v Version) Compare(o Version) int {
+	for _, d := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if d[0] < d[1] {
+			return -1
+		}
+		if d[0] > d[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// true if v >= o
+func (v Version) AtLeast(o Version) bool {
+	return v.Compare(o) >= 0
+}
+
+// true if no version has been set
+func (v Version) IsZero() bool {
+	return v.Major == 0 && v.Minor == 0 && v.Patch == 0
+}
+
 // returns the semantic version of airflow cli
 func (cli *CLIENT) GetVersion() (string, error) {
 	cmd := `version`
@@ -11,3 +82,29 @@ func (cli *CLIENT) GetVersion() (string, error) {
 	}
 	return data.StdoutStr, nil
 }
+
+// returns the parsed airflow version of the environment, detected once with `airflow version` and cached on the client
+func (cli *CLIENT) AirflowVersion() (Version, error) {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	if cli.version != nil {
+		return *cli.version, nil
+	}
+	raw, err := cli.GetVersion()
+	if err != nil {
+		return Version{}, err
+	}
+	v, err := ParseVersion(raw)
+	if err != nil {
+		return Version{}, err
+	}
+	cli.version = &v
+	return v, nil
+}
+
+// pins the airflow version used for capability checks, skipping detection
+func (cli *CLIENT) SetAirflowVersion(v Version) {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	cli.version = &v
+}
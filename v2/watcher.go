@@ -0,0 +1,320 @@
+// Copyright (c) Warner Media, LLC. All rights reserved. Licensed under the MIT license.
+// See the LICENSE file for license information.
+package mwaah
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apache/airflow-client-go/airflow"
+)
+
+// kind of change a RunEvent reports
+type RunEventType string
+
+const (
+	// a run that was not listed before
+	RunAdded RunEventType = "added"
+	// a run whose state changed
+	RunStateChanged RunEventType = "state_changed"
+	// a run that is no longer listed, e.g. deleted
+	RunRemoved RunEventType = "removed"
+)
+
+// a change of a dag run between two polls
+type RunEvent struct {
+	Type  RunEventType `json:"type"`
+	DagId string       `json:"dag_id"`
+	RunId string       `json:"run_id"`
+	// empty for added runs
+	From airflow.DagState `json:"from,omitempty"`
+	// empty for removed runs
+	To airflow.DagState `json:"to,omitempty"`
+	// the run as last listed
+	Run  airflow.DAGRun `json:"run"`
+	Time time.Time      `json:"time"`
+}
+
+// optional args for NewWatcher
+type WatcherOptions struct {
+	// DAGs to watch
+	DagIds []string
+	// watch the DAGs matching Selector, resolved on every poll, in addition to DagIds
+	Selector *DagQuery
+	// time between polls while runs are active, defaults to 15s
+	MinInterval time.Duration
+	// time between polls once everything is idle, defaults to 5m
+	MaxInterval time.Duration
+	// file the last seen run states are kept in, so a restarted watcher does not emit old transitions again
+	Checkpoint string
+	// size of the Events channel, defaults to 64
+	Buffer int
+	// called for DAGs whose runs could not be listed, the watcher carries on; when nil Run returns the error
+	OnError func(dagId string, err error)
+}
+
+// last seen state of every run, by dag id then run id
+type WatcherCheckpoint struct {
+	UpdatedAt time.Time                              `json:"updated_at"`
+	Runs      map[string]map[string]airflow.DagState `json:"runs"`
+	// logical date the runs of every DAG are listed from, by dag id
+	Since map[string]time.Time `json:"since,omitempty"`
+	// logical date of every run in Runs, so a restarted watcher knows which runs the bounded listing still covers
+	LogicalDates map[string]map[string]time.Time `json:"logical_dates,omitempty"`
+}
+
+// polls the runs of selected DAGs and emits a RunEvent for every change
+type Watcher struct {
+	cli    *CLIENT
+	opts   WatcherOptions
+	events chan RunEvent
+	runs   map[string]map[string]airflow.DagState
+	since  map[string]time.Time
+	// logical dates of the runs last listed, by dag id then run id
+	dates map[string]map[string]time.Time
+}
+
+// returns a Watcher, call Run to start it
+func (cli *CLIENT) NewWatcher(opts WatcherOptions) *Watcher {
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = 15 * time.Second
+	}
+	if opts.MaxInterval < opts.MinInterval {
+		opts.MaxInterval = 5 * time.Minute
+		if opts.MaxInterval < opts.MinInterval {
+			opts.MaxInterval = opts.MinInterval
+		}
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+	return &Watcher{cli: cli, opts: opts, events: make(chan RunEvent, opts.Buffer)}
+}
+
+// the channel events are sent on, closed when Run returns
+func (w *Watcher) Events() <-chan RunEvent {
+	return w.events
+}
+
+// compares the runs of dagId against the states seen before
+func diffRuns(dagId string, previous map[string]airflow.DagState, runs []airflow.DAGRun, now time.Time) []RunEvent {
+	events := []RunEvent{}
+	listed := map[string]bool{}
+	for _, run := range runs {
+		runId := run.GetDagRunId()
+		listed[runId] = true
+		state := run.GetState()
+		was, seen := previous[runId]
+		switch {
+		case !seen:
+			events = append(events, RunEvent{Type: RunAdded, DagId: dagId, RunId: runId, To: state, Run: run, Time: now})
+		case was != state:
+			events = append(events, RunEvent{Type: RunStateChanged, DagId: dagId, RunId: runId, From: was, To: state, Run: run, Time: now})
+		}
+	}
+	var removed []string
+	for runId := range previous {
+		if !listed[runId] {
+			removed = append(removed, runId)
+		}
+	}
+	sort.Strings(removed)
+	for _, runId := range removed {
+		run := airflow.NewDAGRun()
+		run.SetDagId(dagId)
+		run.SetDagRunId(runId)
+		events = append(events, RunEvent{Type: RunRemoved, DagId: dagId, RunId: runId, From: previous[runId], Run: *run, Time: now})
+	}
+	return events
+}
+
+// returns the logical date to list the runs of a DAG from next: the earliest of its unfinished runs,
+// or the latest run once all are finished, so finished runs drop out of the listing
+func nextWatchStart(runs []airflow.DAGRun, since time.Time) time.Time {
+	var earliest, latest time.Time
+	for _, run := range runs {
+		date := runLogicalDate(run)
+		if !isTerminalDagState(run.GetState()) && (earliest.IsZero() || date.Before(earliest)) {
+			earliest = date
+		}
+		if date.After(latest) {
+			latest = date
+		}
+	}
+	switch {
+	case !earliest.IsZero():
+		return earliest
+	case !latest.IsZero():
+		return latest
+	}
+	return since
+}
+
+// returns the runs of previous a listing from since includes, runs of unknown logical date are left out once bounded
+func runsInWindow(previous map[string]airflow.DagState, dates map[string]time.Time, since time.Time) map[string]airflow.DagState {
+	if since.IsZero() {
+		return previous
+	}
+	// list-runs takes dates, so the listing starts at midnight
+	start := since.UTC().Truncate(24 * time.Hour)
+	in := map[string]airflow.DagState{}
+	for runId, state := range previous {
+		date, known := dates[runId]
+		if !known {
+			date = logicalDateOf(runId)
+		}
+		if !date.IsZero() && !date.Before(start) {
+			in[runId] = state
+		}
+	}
+	return in
+}
+
+// returns the DAGs to poll, sorted
+func (w *Watcher) dagIds() ([]string, error) {
+	ids := map[string]bool{}
+	for _, dagId := range w.opts.DagIds {
+		ids[dagId] = true
+	}
+	if w.opts.Selector != nil {
+		dags, err := w.cli.QueryDags(*w.opts.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dags {
+			ids[d.DagId] = true
+		}
+	}
+	dagIds := []string{}
+	for dagId := range ids {
+		dagIds = append(dagIds, dagId)
+	}
+	sort.Strings(dagIds)
+	return dagIds, nil
+}
+
+func (w *Watcher) loadCheckpoint() error {
+	w.runs = map[string]map[string]airflow.DagState{}
+	w.since = map[string]time.Time{}
+	w.dates = map[string]map[string]time.Time{}
+	if w.opts.Checkpoint == "" {
+		return nil
+	}
+	data, err := os.ReadFile(w.opts.Checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var checkpoint WatcherCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return fmt.Errorf("invalid watcher checkpoint %s: %w", w.opts.Checkpoint, err)
+	}
+	if checkpoint.Runs != nil {
+		w.runs = checkpoint.Runs
+	}
+	if checkpoint.Since != nil {
+		w.since = checkpoint.Since
+	}
+	if checkpoint.LogicalDates != nil {
+		w.dates = checkpoint.LogicalDates
+	}
+	return nil
+}
+
+func (w *Watcher) saveCheckpoint() error {
+	if w.opts.Checkpoint == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(WatcherCheckpoint{UpdatedAt: time.Now().UTC(), Runs: w.runs, Since: w.since, LogicalDates: w.dates}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(w.opts.Checkpoint, data)
+}
+
+// lists the runs of every watched DAG and sends the changes, returns true if any run is active or changed
+func (w *Watcher) poll(ctx context.Context) (bool, error) {
+	dagIds, err := w.dagIds()
+	if err != nil {
+		return false, err
+	}
+	active := false
+	for _, dagId := range dagIds {
+		since := w.since[dagId]
+		runs, err := w.cli.ListDagRuns(ListDagRunsInput{DagId: dagId, StartDate: since})
+		if err != nil {
+			if w.opts.OnError == nil {
+				return active, err
+			}
+			w.opts.OnError(dagId, err)
+			continue
+		}
+		previous, watched := w.runs[dagId]
+		states := map[string]airflow.DagState{}
+		dates := map[string]time.Time{}
+		for _, run := range runs {
+			states[run.GetDagRunId()] = run.GetState()
+			dates[run.GetDagRunId()] = runLogicalDate(run)
+			if !isTerminalDagState(run.GetState()) {
+				active = true
+			}
+		}
+		// the first listing of a DAG is the baseline everything later is compared to
+		if watched {
+			// runs listed before that fall before since are finished, not removed
+			previous = runsInWindow(previous, w.dates[dagId], since)
+			for _, event := range diffRuns(dagId, previous, runs, time.Now().UTC()) {
+				active = true
+				select {
+				case w.events <- event:
+				case <-ctx.Done():
+					return active, ctx.Err()
+				}
+			}
+		}
+		w.runs[dagId], w.dates[dagId] = states, dates
+		w.since[dagId] = nextWatchStart(runs, since)
+	}
+	return active, w.saveCheckpoint()
+}
+
+/*
+Run polls until ctx is done or polling fails, then closes the Events channel
+
+The first time a DAG is listed, without a checkpoint, its runs are recorded without emitting events.
+Later polls only list runs from the logical date of the earliest unfinished run, or of the latest run once all are finished,
+so runs triggered for an older logical date, or older runs cleared to run again, are not seen.
+Polls happen every MinInterval while runs are queued, running or changing, and back off towards MaxInterval while everything is idle.
+*/
+func (w *Watcher) Run(ctx context.Context) error {
+	defer close(w.events)
+	if err := w.loadCheckpoint(); err != nil {
+		return err
+	}
+	interval := w.opts.MinInterval
+	for {
+		active, err := w.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if active {
+			interval = w.opts.MinInterval
+		} else if interval *= 2; interval > w.opts.MaxInterval {
+			interval = w.opts.MaxInterval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}